@@ -1,11 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 
+	"github.com/grantwforsythe/monkeylang/pkg/code"
+	"github.com/grantwforsythe/monkeylang/pkg/compiler"
+	"github.com/grantwforsythe/monkeylang/pkg/object"
 	"github.com/grantwforsythe/monkeylang/pkg/repl"
+	"github.com/grantwforsythe/monkeylang/pkg/runner"
 )
 
 const MONKEY_FACE = `            __,__
@@ -21,7 +30,43 @@ const MONKEY_FACE = `            __,__
            '-----'
 `
 
+const USAGE = `Usage:
+  monkey [--engine=eval|vm]                   Start the interactive REPL
+  monkey [--engine=eval|vm] run <file | ->    Run a Monkey script, use - to read the script from stdin
+                                              Bytecode files are always run with the vm engine
+  monkey build <file> [-o <output>]           Compile a Monkey script to a bytecode file, foo.monkey is
+                                              compiled to foo.mbc by default
+  monkey disasm <file | ->                    Print the bytecode of a Monkey script or bytecode file
+`
+
 func main() {
+	engine := flag.String("engine", string(runner.EngineEval), "the engine used to execute programs: eval or vm")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), USAGE)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *engine != string(runner.EngineEval) && *engine != string(runner.EngineVM) {
+		fmt.Fprintf(os.Stderr, "unknown engine %q, expected eval or vm\n", *engine)
+		os.Exit(2)
+	}
+
+	args := flag.Args()
+	if len(args) > 0 {
+		switch args[0] {
+		case "run":
+			os.Exit(run(args[1:], runner.Engine(*engine)))
+		case "build":
+			os.Exit(build(args[1:]))
+		case "disasm":
+			os.Exit(disasm(args[1:]))
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -32,5 +77,171 @@ func main() {
 	fmt.Printf("Feel free to type in commands.\n")
 	fmt.Printf("Call `quit()` to quit.\n")
 
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, runner.Engine(*engine))
+}
+
+// run executes the script at the path given by args, reading from stdin if the path is -.
+// Returns the exit status for the process.
+func run(args []string, engine runner.Engine) int {
+	if len(args) != 1 {
+		flag.Usage()
+		return 2
+	}
+
+	file, source, err := readScript(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Bytecode is recognised by its header rather than the extension of the file so that it can be piped in as well
+	if bytes.HasPrefix(source, []byte(compiler.ByteCodeMagic)) {
+		bytecode := &compiler.ByteCode{}
+		err = bytecode.UnmarshalBinary(source)
+		if err == nil {
+			err = runner.RunByteCode(bytecode)
+		}
+	} else {
+		err = runner.Run(file, string(source), engine)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// build compiles the script at the path given by args to a bytecode file.
+// Returns the exit status for the process.
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := flags.String("o", "", "the path of the bytecode file, defaults to the script with the extension .mbc")
+	flags.Usage = flag.Usage
+
+	// The output flag may come before or after the script
+	var files []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return 2
+		}
+
+		if flags.NArg() == 0 {
+			break
+		}
+
+		files = append(files, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if len(files) != 1 {
+		flag.Usage()
+		return 2
+	}
+
+	file := files[0]
+	if *output == "" {
+		*output = strings.TrimSuffix(file, filepath.Ext(file)) + ".mbc"
+	}
+
+	source, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	bytecode, err := runner.Compile(file, string(source))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	data, err := bytecode.MarshalBinary()
+	if err == nil {
+		err = os.WriteFile(*output, data, 0o644)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// disasm prints the disassembled bytecode of the script or bytecode file at the path given by args, reading from stdin
+// if the path is -.
+// Returns the exit status for the process.
+func disasm(args []string) int {
+	if len(args) != 1 {
+		flag.Usage()
+		return 2
+	}
+
+	file, source, err := readScript(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	bytecode := &compiler.ByteCode{}
+	sources := map[string]string{}
+	if bytes.HasPrefix(source, []byte(compiler.ByteCodeMagic)) {
+		err = bytecode.UnmarshalBinary(source)
+		if err == nil {
+			sources = readSources(bytecode)
+		}
+	} else {
+		bytecode, err = runner.Compile(file, string(source))
+		sources[file] = string(source)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	bytecode.Disassemble(os.Stdout, sources)
+
+	return 0
+}
+
+// readScript reads the script at the path, or stdin if the path is -.
+// Returns the name of the file to report in errors, which is empty for stdin, and the contents of the script.
+func readScript(path string) (string, []byte, error) {
+	if path == "-" {
+		source, err := io.ReadAll(os.Stdin)
+		return "", source, err
+	}
+
+	source, err := os.ReadFile(path)
+	return path, source, err
+}
+
+// readSources reads every source file that the bytecode was compiled from and that still exists.
+// Returns a map of the name of each file to its contents.
+func readSources(bytecode *compiler.ByteCode) map[string]string {
+	sourceMaps := []code.SourceMap{bytecode.SourceMap}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			sourceMaps = append(sourceMaps, fn.SourceMap)
+		}
+	}
+
+	sources := map[string]string{}
+	for _, sourceMap := range sourceMaps {
+		for _, pos := range sourceMap {
+			if _, ok := sources[pos.File]; ok || pos.File == "" {
+				continue
+			}
+
+			// The source is only used to annotate the listing so a file that has since been moved is left out
+			if source, err := os.ReadFile(pos.File); err == nil {
+				sources[pos.File] = string(source)
+			}
+		}
+	}
+
+	return sources
 }
@@ -352,6 +352,28 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestWhileStatement(t *testing.T) {
+	stmt := &WhileStatement{
+		Token:     token.Token{Type: token.WHILE, Literal: "while"},
+		Condition: &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"},
+		Body: &BlockStatement{
+			Token: token.Token{Type: token.LBRACE, Literal: "{"},
+			Statements: []Statement{
+				&BreakStatement{Token: token.Token{Type: token.BREAK, Literal: "break"}},
+			},
+		},
+	}
+
+	if stmt.TokenLiteral() != "while" {
+		t.Errorf("stmt.TokenLiteral() is not equal to 'while'. got=%s", stmt.TokenLiteral())
+	}
+
+	expected := "while (x) break;"
+	if stmt.String() != expected {
+		t.Errorf("stmt.String() is not equal to '%s'. got=%s", expected, stmt.String())
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	exp := &StringLiteral{
 		Token: token.Token{Type: token.STRING, Literal: "foobar"},
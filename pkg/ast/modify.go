@@ -18,6 +18,7 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		node.Expression, _ = Modify(node.Expression, modifier).(Expression)
 
 	case *CallExpression:
+		node.Function, _ = Modify(node.Function, modifier).(Expression)
 		for i, argument := range node.Arguments {
 			node.Arguments[i], _ = Modify(argument, modifier).(Expression)
 		}
@@ -26,6 +27,10 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		node.Left, _ = Modify(node.Left, modifier).(Expression)
 		node.Right, _ = Modify(node.Right, modifier).(Expression)
 
+	case *AssignExpression:
+		node.Target, _ = Modify(node.Target, modifier).(Expression)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+
 	case *PrefixExpression:
 		node.Right, _ = Modify(node.Right, modifier).(Expression)
 
@@ -51,6 +56,22 @@ func Modify(node Node, modifier ModifierFunc) Node {
 	case *LetStatement:
 		node.Value, _ = Modify(node.Value, modifier).(Expression)
 
+	case *WhileStatement:
+		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+
+	case *ForStatement:
+		if node.Init != nil {
+			node.Init, _ = Modify(node.Init, modifier).(Statement)
+		}
+		if node.Condition != nil {
+			node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		}
+		if node.Post != nil {
+			node.Post, _ = Modify(node.Post, modifier).(Statement)
+		}
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+
 	case *FunctionLiteral:
 		if node.Parameters != nil {
 			for i, parameter := range node.Parameters {
@@ -64,6 +85,11 @@ func Modify(node Node, modifier ModifierFunc) Node {
 			node.Elements[i], _ = Modify(element, modifier).(Expression)
 		}
 
+	case *InterpolatedString:
+		for i, part := range node.Parts {
+			node.Parts[i], _ = Modify(part, modifier).(Expression)
+		}
+
 	case *HashLiteral:
 		modifiedPairs := make(map[Expression]Expression)
 
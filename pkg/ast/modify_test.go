@@ -86,6 +86,47 @@ func TestModify(t *testing.T) {
 		},
 		{&ReturnStatement{ReturnValue: one()}, &ReturnStatement{ReturnValue: two()}},
 		{&LetStatement{Value: one()}, &LetStatement{Value: two()}},
+		{
+			&WhileStatement{
+				Condition: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&WhileStatement{
+				Condition: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ForStatement{
+				Init:      &LetStatement{Value: one()},
+				Condition: one(),
+				Post:      &ExpressionStatement{Expression: one()},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&ForStatement{
+				Init:      &LetStatement{Value: two()},
+				Condition: two(),
+				Post:      &ExpressionStatement{Expression: two()},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{&ForStatement{Body: &BlockStatement{}}, &ForStatement{Body: &BlockStatement{}}},
 		{
 			&FunctionLiteral{
 				Body: &BlockStatement{
@@ -106,6 +147,10 @@ func TestModify(t *testing.T) {
 			&ArrayLiteral{Elements: []Expression{one(), two()}},
 			&ArrayLiteral{Elements: []Expression{two(), two()}},
 		},
+		{
+			&InterpolatedString{Parts: []Expression{&StringLiteral{Value: "a"}, one(), &StringLiteral{Value: "b"}}},
+			&InterpolatedString{Parts: []Expression{&StringLiteral{Value: "a"}, two(), &StringLiteral{Value: "b"}}},
+		},
 	}
 
 	for _, test := range tests {
@@ -9,6 +9,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+
+	"github.com/grantwforsythe/monkeylang/pkg/token"
 )
 
 // Instructions represents instructions for the virtual machine.
@@ -19,23 +21,43 @@ type Instructions []byte
 func (ins Instructions) String() string {
 	var out bytes.Buffer
 
+	ins.Disassemble(func(offset int, text string) {
+		fmt.Fprintf(&out, "%04d %s\n", offset, text)
+	})
+
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// Disassemble decodes the instructions one at a time and calls fn with the offset and the text of each one, e.g.
+// "OpConstant 1". Bytes that can not be decoded are reported with a text starting with ERROR.
+func (ins Instructions) Disassemble(fn func(offset int, text string)) {
 	// i represents the index of the instruction in the slice of instructions
 	i := 0
 	for i < len(ins) {
 		definition, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			// Skip the unknown opcode, the bytes that follow it may still be valid instructions
+			fn(i, fmt.Sprintf("ERROR: %s", err))
+			i++
 			continue
 		}
 
+		var width int
+		for _, w := range definition.OperandWidths {
+			width += w
+		}
+
 		// +1 because the ith position is the opcode
+		if i+1+width > len(ins) {
+			fn(i, fmt.Sprintf("ERROR: %s is missing its operands", definition.Name))
+			return
+		}
+
 		operands, offset := ReadOperands(definition, ins[i+1:])
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(definition, operands))
+		fn(i, ins.fmtInstruction(definition, operands))
 
 		i += 1 + offset
 	}
-
-	return strings.TrimRight(out.String(), "\n")
 }
 
 // TODO: Rename method
@@ -54,6 +76,8 @@ func (ins Instructions) fmtInstruction(definition *Definition, operands []int) s
 		return definition.Name
 	case 1:
 		return fmt.Sprintf("%s %d", definition.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", definition.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operand count for %s\n", definition.Name)
@@ -64,19 +88,48 @@ type Opcode byte
 
 // We let iota generate the byte values because the actual values do not matter.
 const (
-	OpConstant Opcode = iota // OpConstant retrives the constant using the operand as an index and pushes it onto the stack.
-	OpAdd                    // OpAdd pops two objects off the stack, adds them together, and adds the result on the stack.
-	OpPop                    // OpPop pops the top most element off the stack
-	OpSub                    // OpSub pops two objects off the stack, subtracts them, and pushes the result onto the stack.
-	OpDiv                    // OpDiv pops two objects off the stack, divdes them, and pushes the result onto the stack.
-	OpMul                    // OpMul pops two objects off the stack, multiples them, and pushes the result onto the stack.
-	OpTrue                   // OpTrue push a boolean object with a value of true onto the stack.
-	OpFalse                  // OpFalse push a boolean object with a value of false onto the stack.
-	OpEQ                     // OpEQ compares the two top most elemensts on the stack ensuring they are equal, ==.
-	OpNEQ                    // OpNEQ compares the two top most elements on the stack ensuring they are not equal, !=.
-	OpGT                     // OpGT compares the two top most elements on the stack ensuring one is greater than the other. The elements are reordered if they are less than.
-	OpBang                   // OpBang negates a boolean expression.
-	OpMinus                  // OpMinus multiples an integer on the stack by -1.
+	OpConstant       Opcode = iota // OpConstant retrives the constant using the operand as an index and pushes it onto the stack.
+	OpAdd                          // OpAdd pops two objects off the stack, adds them together, and adds the result on the stack.
+	OpPop                          // OpPop pops the top most element off the stack
+	OpSub                          // OpSub pops two objects off the stack, subtracts them, and pushes the result onto the stack.
+	OpDiv                          // OpDiv pops two objects off the stack, divdes them, and pushes the result onto the stack.
+	OpMul                          // OpMul pops two objects off the stack, multiples them, and pushes the result onto the stack.
+	OpTrue                         // OpTrue push a boolean object with a value of true onto the stack.
+	OpFalse                        // OpFalse push a boolean object with a value of false onto the stack.
+	OpEQ                           // OpEQ compares the two top most elemensts on the stack ensuring they are equal, ==.
+	OpNEQ                          // OpNEQ compares the two top most elements on the stack ensuring they are not equal, !=.
+	OpGT                           // OpGT compares the two top most elements on the stack ensuring one is greater than the other.
+	OpBang                         // OpBang negates a boolean expression.
+	OpMinus                        // OpMinus multiples an integer on the stack by -1.
+	OpJumpNotTruthy                // OpJumpNotTruthy pops the top most element off the stack and jumps to the operand if it is not truthy.
+	OpJump                         // OpJump jumps to the instruction at the position given by the operand.
+	OpNull                         // OpNull pushes a null object onto the stack.
+	OpGetGlobal                    // OpGetGlobal pushes the global binding at the index given by the operand onto the stack.
+	OpSetGlobal                    // OpSetGlobal pops the top most element off the stack and binds it to the global at the index given by the operand.
+	OpGetLocal                     // OpGetLocal pushes the local binding at the index given by the operand onto the stack.
+	OpSetLocal                     // OpSetLocal pops the top most element off the stack and binds it to the local at the index given by the operand.
+	OpCall                         // OpCall calls the function below the number of arguments given by the operand on the stack.
+	OpReturnValue                  // OpReturnValue returns from the current function with the top most element on the stack.
+	OpReturn                       // OpReturn returns from the current function with null.
+	OpClosure                      // OpClosure wraps the compiled function at the constant index given by the first operand in a closure, capturing the number of free variables given by the second operand off the stack.
+	OpGetFree                      // OpGetFree pushes the free variable of the current closure at the index given by the operand onto the stack.
+	OpCurrentClosure               // OpCurrentClosure pushes the closure that is currently being executed onto the stack.
+	OpArray                        // OpArray pops the number of elements given by the operand off the stack and pushes an array containing them onto the stack.
+	OpHash                         // OpHash pops the number of keys and values given by the operand off the stack and pushes a hash containing them onto the stack.
+	OpIndex                        // OpIndex pops an index and the object being indexed off the stack and pushes the element at that index onto the stack.
+	OpGetBuiltin                   // OpGetBuiltin pushes the builtin function at the index given by the operand onto the stack.
+	OpCell                         // OpCell wraps the value on top of the stack in a cell so that closures can share and assign to it.
+	OpDeref                        // OpDeref replaces the cell on top of the stack with the value it holds.
+	OpSetCell                      // OpSetCell stores the value below the top of the stack in the cell on top of the stack.
+	OpSetIndex                     // OpSetIndex stores a value in an array or hash at an index, leaving the value on the stack.
+	OpDup                          // OpDup duplicates the given number of elements on top of the stack.
+	OpMod                          // OpMod pops two objects off the stack, divides them, and pushes the remainder onto the stack.
+	OpPow                          // OpPow pops two objects off the stack, raises the first to the power of the second, and pushes the result onto the stack.
+	OpGTE                          // OpGTE compares the two top most elements on the stack ensuring one is greater than or equal to the other.
+	OpConcat                       // OpConcat pops the given number of objects off the stack and pushes the concatenation of their string representations onto the stack.
+	OpLT                           // OpLT compares the two top most elements on the stack ensuring one is less than the other.
+	OpLTE                          // OpLTE compares the two top most elements on the stack ensuring one is less than or equal to the other.
+	OpEmptyCell                    // OpEmptyCell binds a cell without a value to the local at the index given by the operand, until the let statement defining the local runs.
 )
 
 // Definition represents the definition for an Opcode.
@@ -88,19 +141,48 @@ type Definition struct {
 // The slices are being created with the make function because we want to avoid the problems that arise with nil
 
 var definitions = map[Opcode]*Definition{
-	OpConstant: {"OpConstant", []int{2}},
-	OpAdd:      {"OpAdd", make([]int, 0)},
-	OpPop:      {"OpPop", make([]int, 0)},
-	OpSub:      {"OpSub", make([]int, 0)},
-	OpDiv:      {"OpDiv", make([]int, 0)},
-	OpMul:      {"OpMul", make([]int, 0)},
-	OpTrue:     {"OpTrue", make([]int, 0)},
-	OpFalse:    {"OpFalse", make([]int, 0)},
-	OpEQ:       {"OpEQ", make([]int, 0)},
-	OpNEQ:      {"OpNEQ", make([]int, 0)},
-	OpGT:       {"OpGT", make([]int, 0)},
-	OpBang:     {"OpBang", make([]int, 0)},
-	OpMinus:    {"OpMinus", make([]int, 0)},
+	OpConstant:       {"OpConstant", []int{2}},
+	OpAdd:            {"OpAdd", make([]int, 0)},
+	OpPop:            {"OpPop", make([]int, 0)},
+	OpSub:            {"OpSub", make([]int, 0)},
+	OpDiv:            {"OpDiv", make([]int, 0)},
+	OpMul:            {"OpMul", make([]int, 0)},
+	OpTrue:           {"OpTrue", make([]int, 0)},
+	OpFalse:          {"OpFalse", make([]int, 0)},
+	OpEQ:             {"OpEQ", make([]int, 0)},
+	OpNEQ:            {"OpNEQ", make([]int, 0)},
+	OpGT:             {"OpGT", make([]int, 0)},
+	OpBang:           {"OpBang", make([]int, 0)},
+	OpMinus:          {"OpMinus", make([]int, 0)},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpJump:           {"OpJump", []int{2}},
+	OpNull:           {"OpNull", make([]int, 0)},
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", make([]int, 0)},
+	OpReturn:         {"OpReturn", make([]int, 0)},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", make([]int, 0)},
+	OpArray:          {"OpArray", []int{2}},
+	OpHash:           {"OpHash", []int{2}},
+	OpIndex:          {"OpIndex", make([]int, 0)},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpCell:           {"OpCell", make([]int, 0)},
+	OpDeref:          {"OpDeref", make([]int, 0)},
+	OpSetCell:        {"OpSetCell", make([]int, 0)},
+	OpSetIndex:       {"OpSetIndex", make([]int, 0)},
+	OpDup:            {"OpDup", []int{1}},
+	OpMod:            {"OpMod", make([]int, 0)},
+	OpPow:            {"OpPow", make([]int, 0)},
+	OpGTE:            {"OpGTE", make([]int, 0)},
+	OpConcat:         {"OpConcat", []int{2}},
+	OpLT:             {"OpLT", make([]int, 0)},
+	OpLTE:            {"OpLTE", make([]int, 0)},
+	OpEmptyCell:      {"OpEmptyCell", []int{1}},
 }
 
 // Lookup gets the Opcode definition for a given byte.
@@ -136,6 +218,8 @@ func Make(op Opcode, operands ...int) Instructions {
 		switch definition.OperandWidths[i] {
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(operand))
+		case 1:
+			instruction[offset] = byte(operand)
 		}
 		offset += definition.OperandWidths[i]
 	}
@@ -153,6 +237,8 @@ func ReadOperands(definition *Definition, instruction Instructions) ([]int, int)
 		switch width {
 		case 2:
 			operands[i] = int(ReadUint16(instruction[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(instruction[offset:]))
 		}
 
 		offset += width
@@ -166,3 +252,28 @@ func ReadOperands(definition *Definition, instruction Instructions) ([]int, int)
 func ReadUint16(instruction Instructions) uint16 {
 	return binary.BigEndian.Uint16(instruction)
 }
+
+// ReadUint8 reads a single byte operand from an instruction.
+func ReadUint8(instruction Instructions) uint8 {
+	return uint8(instruction[0])
+}
+
+// SourceMap maps the offset of an instruction to the position in the source code it was compiled from.
+type SourceMap map[int]token.Position
+
+// Lookup finds the position of the instruction that contains the given offset, i.e. the closest instruction at or before it.
+// Returns false if no position is known for the offset.
+func (s SourceMap) Lookup(offset int) (token.Position, bool) {
+	closest := -1
+	for ins := range s {
+		if ins <= offset && ins > closest {
+			closest = ins
+		}
+	}
+
+	if closest == -1 {
+		return token.Position{}, false
+	}
+
+	return s[closest], true
+}
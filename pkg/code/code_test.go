@@ -1,6 +1,7 @@
 package code
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -22,8 +23,38 @@ func TestMake(t *testing.T) {
 		{OpEQ, []int{}, []byte{byte(OpEQ)}},
 		{OpNEQ, []int{}, []byte{byte(OpNEQ)}},
 		{OpGT, []int{}, []byte{byte(OpGT)}},
+		{OpGTE, []int{}, []byte{byte(OpGTE)}},
+		{OpLT, []int{}, []byte{byte(OpLT)}},
+		{OpLTE, []int{}, []byte{byte(OpLTE)}},
+		{OpMod, []int{}, []byte{byte(OpMod)}},
+		{OpPow, []int{}, []byte{byte(OpPow)}},
 		{OpMinus, []int{}, []byte{byte(OpMinus)}},
 		{OpBang, []int{}, []byte{byte(OpBang)}},
+		{OpJumpNotTruthy, []int{65534}, []byte{byte(OpJumpNotTruthy), 255, 254}},
+		{OpJump, []int{65534}, []byte{byte(OpJump), 255, 254}},
+		{OpNull, []int{}, []byte{byte(OpNull)}},
+		{OpGetGlobal, []int{65534}, []byte{byte(OpGetGlobal), 255, 254}},
+		{OpSetGlobal, []int{65534}, []byte{byte(OpSetGlobal), 255, 254}},
+		// OpGetLocal's operand is one byte wide meaning 255 is the highest value that can be represented.
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpSetLocal, []int{255}, []byte{byte(OpSetLocal), 255}},
+		{OpEmptyCell, []int{255}, []byte{byte(OpEmptyCell), 255}},
+		{OpCall, []int{255}, []byte{byte(OpCall), 255}},
+		{OpReturnValue, []int{}, []byte{byte(OpReturnValue)}},
+		{OpReturn, []int{}, []byte{byte(OpReturn)}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpGetFree, []int{255}, []byte{byte(OpGetFree), 255}},
+		{OpCurrentClosure, []int{}, []byte{byte(OpCurrentClosure)}},
+		{OpArray, []int{65534}, []byte{byte(OpArray), 255, 254}},
+		{OpConcat, []int{3}, []byte{byte(OpConcat), 0, 3}},
+		{OpHash, []int{65534}, []byte{byte(OpHash), 255, 254}},
+		{OpIndex, []int{}, []byte{byte(OpIndex)}},
+		{OpGetBuiltin, []int{255}, []byte{byte(OpGetBuiltin), 255}},
+		{OpCell, []int{}, []byte{byte(OpCell)}},
+		{OpDeref, []int{}, []byte{byte(OpDeref)}},
+		{OpSetCell, []int{}, []byte{byte(OpSetCell)}},
+		{OpSetIndex, []int{}, []byte{byte(OpSetIndex)}},
+		{OpDup, []int{2}, []byte{byte(OpDup), 2}},
 	}
 
 	for _, test := range tests {
@@ -63,6 +94,26 @@ func TestInstructionsString(t *testing.T) {
 				Make(OpConstant, 2),
 				Make(OpConstant, 65534),
 			}, "0000 OpAdd\n0001 OpConstant 2\n0004 OpConstant 65534"},
+		{
+			[]Instructions{
+				Make(OpGetLocal, 1),
+				Make(OpSetGlobal, 2),
+			}, "0000 OpGetLocal 1\n0002 OpSetGlobal 2"},
+		{
+			[]Instructions{
+				Make(OpClosure, 65535, 255),
+				Make(OpCall, 1),
+			}, "0000 OpClosure 65535 255\n0004 OpCall 1"},
+		{
+			[]Instructions{
+				{255},
+				Make(OpAdd),
+			}, "0000 ERROR: opcode 255 is not defined\n0001 OpAdd"},
+		{
+			[]Instructions{
+				Make(OpAdd),
+				Make(OpConstant, 1)[:2],
+			}, "0000 OpAdd\n0001 ERROR: OpConstant is missing its operands"},
 	}
 
 	for _, test := range tests {
@@ -100,6 +151,23 @@ func TestReadOperands(t *testing.T) {
 		{OpGT, []int{}, 0},
 		{OpMinus, []int{}, 0},
 		{OpBang, []int{}, 0},
+		{OpJumpNotTruthy, []int{65535}, 2},
+		{OpJump, []int{65535}, 2},
+		{OpNull, []int{}, 0},
+		{OpGetGlobal, []int{65535}, 2},
+		{OpSetGlobal, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpSetLocal, []int{255}, 1},
+		{OpCall, []int{255}, 1},
+		{OpReturnValue, []int{}, 0},
+		{OpReturn, []int{}, 0},
+		{OpClosure, []int{65535, 255}, 3},
+		{OpGetFree, []int{255}, 1},
+		{OpCurrentClosure, []int{}, 0},
+		{OpArray, []int{65535}, 2},
+		{OpHash, []int{65535}, 2},
+		{OpIndex, []int{}, 0},
+		{OpGetBuiltin, []int{255}, 1},
 	}
 
 	for _, test := range tests {
@@ -122,3 +190,18 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func FuzzInstructionsString(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte(Make(OpConstant, 1)))
+	f.Add([]byte(append(Make(OpClosure, 65535, 255), Make(OpCall, 1)...)))
+	f.Add([]byte{255, 0, 1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Every instruction, valid or not, takes up at least one line
+		lines := strings.Count(Instructions(data).String(), "\n") + 1
+		if len(data) > 0 && lines > len(data) {
+			t.Fatalf("more lines than bytes. lines=%d, bytes=%d", lines, len(data))
+		}
+	})
+}
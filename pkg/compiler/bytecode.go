@@ -0,0 +1,536 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/grantwforsythe/monkeylang/pkg/code"
+	"github.com/grantwforsythe/monkeylang/pkg/object"
+	"github.com/grantwforsythe/monkeylang/pkg/token"
+)
+
+// The bytecode of a program is stored on disk in the following format, every count and length is an unsigned varint:
+//
+//	magic        4 bytes, "MNKY"
+//	version      2 bytes, big endian
+//	constants    count, followed by each constant as a tag byte and its payload
+//	instructions length, followed by the instructions of the main program
+//	source map   count, followed by each entry as its offset, file, line and column
+//	globals      count, followed by the name of each global
+//	checksum     4 bytes, big endian CRC-32 (IEEE) of everything before it
+//
+// The payload of each constant depends on its tag:
+//
+//	integer           signed varint
+//	big integer       sign byte (1 if negative, 0 otherwise), length and the big endian bytes of the absolute value
+//	float             8 bytes, big endian IEEE 754 bits
+//	string            length and the UTF-8 bytes
+//	compiled function number of locals, number of parameters, count followed by the name of each local, instructions
+//	                  and source map
+//
+// Strings, i.e. the file of a source map entry, are stored as their length followed by their bytes.
+
+// ByteCodeMagic represents the bytes at the start of every serialized program.
+const ByteCodeMagic = "MNKY"
+
+// ByteCodeVersion represents the version of the format used to serialize programs.
+// It must be incremented whenever the format or the instruction set changes, older bytecode is rejected rather than
+// executed with the wrong meaning.
+const ByteCodeVersion = 2
+
+// The tags identifying the type of each constant in the constant pool.
+const (
+	tagInteger byte = iota + 1
+	tagBigInteger
+	tagFloat
+	tagString
+	tagCompiledFunction
+)
+
+// MarshalBinary serializes the bytecode so that it can be written to disk and executed later without the source code.
+func (b *ByteCode) MarshalBinary() ([]byte, error) {
+	data := []byte(ByteCodeMagic)
+	data = binary.BigEndian.AppendUint16(data, ByteCodeVersion)
+
+	data = binary.AppendUvarint(data, uint64(len(b.Constants)))
+	for i, constant := range b.Constants {
+		var err error
+		data, err = appendConstant(data, constant)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	data = appendInstructions(data, b.Instructions)
+	data = appendSourceMap(data, b.SourceMap)
+	data = appendStrings(data, b.Globals)
+
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data)), nil
+}
+
+// UnmarshalBinary deserializes bytecode that was serialized with MarshalBinary.
+// Returns an error if the data is not bytecode, was serialized with a different version, or has been corrupted.
+func (b *ByteCode) UnmarshalBinary(data []byte) error {
+	header := len(ByteCodeMagic) + 2
+	if len(data) < header+4 || !bytes.HasPrefix(data, []byte(ByteCodeMagic)) {
+		return fmt.Errorf("not a Monkey bytecode file")
+	}
+
+	if version := binary.BigEndian.Uint16(data[len(ByteCodeMagic):]); version != ByteCodeVersion {
+		return fmt.Errorf("unsupported bytecode version %d, expected %d", version, ByteCodeVersion)
+	}
+
+	body, checksum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return fmt.Errorf("bytecode checksum mismatch, the file is corrupt")
+	}
+
+	d := &decoder{data: body[header:]}
+
+	constants := make([]object.Object, d.count())
+	for i := range constants {
+		constants[i] = d.constant()
+	}
+
+	instructions := d.instructions()
+	sourceMap := d.sourceMap()
+	globals := d.strings()
+	if len(globals) > math.MaxUint16+1 {
+		d.fail("%d globals", len(globals))
+	}
+
+	if d.err != nil {
+		return fmt.Errorf("malformed bytecode: %w", d.err)
+	}
+
+	if len(d.data) != 0 {
+		return fmt.Errorf("malformed bytecode: %d unexpected bytes after the globals", len(d.data))
+	}
+
+	// Every compiled function is checked as well, even if no closure is ever created for it
+	for i, constant := range constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := validateInstructions(fn.Instructions, constants, fn.NumLocals, false); err != nil {
+				return fmt.Errorf("malformed bytecode: constant %d: %w", i, err)
+			}
+		}
+	}
+
+	if err := validateInstructions(instructions, constants, 0, true); err != nil {
+		return fmt.Errorf("malformed bytecode: %w", err)
+	}
+
+	b.Constants = constants
+	b.Instructions = instructions
+	b.SourceMap = sourceMap
+	b.Globals = globals
+
+	return nil
+}
+
+// appendConstant appends the tag and payload of a constant.
+func appendConstant(data []byte, constant object.Object) ([]byte, error) {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		if !constant.IsBig() {
+			data = append(data, tagInteger)
+			return binary.AppendVarint(data, constant.Value), nil
+		}
+
+		var sign byte
+		if constant.Sign() < 0 {
+			sign = 1
+		}
+		data = append(data, tagBigInteger, sign)
+		return appendBytes(data, constant.Big.Bytes()), nil
+	case *object.Float:
+		data = append(data, tagFloat)
+		return binary.BigEndian.AppendUint64(data, math.Float64bits(constant.Value)), nil
+	case *object.String:
+		data = append(data, tagString)
+		return appendBytes(data, []byte(constant.Value)), nil
+	case *object.CompiledFunction:
+		data = append(data, tagCompiledFunction)
+		data = binary.AppendUvarint(data, uint64(constant.NumLocals))
+		data = binary.AppendUvarint(data, uint64(constant.NumParameters))
+		data = appendStrings(data, constant.Locals)
+		data = appendInstructions(data, constant.Instructions)
+		return appendSourceMap(data, constant.SourceMap), nil
+	default:
+		return nil, fmt.Errorf("cannot serialize a constant of type %s", constant.Type())
+	}
+}
+
+func appendBytes(data []byte, b []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(len(b)))
+	return append(data, b...)
+}
+
+func appendStrings(data []byte, strings []string) []byte {
+	data = binary.AppendUvarint(data, uint64(len(strings)))
+	for _, s := range strings {
+		data = appendBytes(data, []byte(s))
+	}
+
+	return data
+}
+
+func appendInstructions(data []byte, ins code.Instructions) []byte {
+	return appendBytes(data, ins)
+}
+
+// appendSourceMap appends the entries of a source map ordered by their offset, so that the same bytecode is always
+// serialized to the same bytes.
+func appendSourceMap(data []byte, sourceMap code.SourceMap) []byte {
+	offsets := make([]int, 0, len(sourceMap))
+	for offset := range sourceMap {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	data = binary.AppendUvarint(data, uint64(len(offsets)))
+	for _, offset := range offsets {
+		pos := sourceMap[offset]
+		data = binary.AppendUvarint(data, uint64(offset))
+		data = appendBytes(data, []byte(pos.File))
+		data = binary.AppendUvarint(data, uint64(pos.Line))
+		data = binary.AppendUvarint(data, uint64(pos.Column))
+	}
+
+	return data
+}
+
+// decoder reads the values written by MarshalBinary. Once an error is found every read returns a zero value, so the
+// error only has to be checked at the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, a...)
+	}
+	d.data = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+
+	b := d.data[0]
+	d.data = d.data[1:]
+
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	value, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("invalid unsigned varint")
+		return 0
+	}
+
+	d.data = d.data[n:]
+	return value
+}
+
+func (d *decoder) varint() int64 {
+	value, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+
+	d.data = d.data[n:]
+	return value
+}
+
+// int reads an unsigned varint that has to fit in an int.
+func (d *decoder) int() int {
+	value := d.uvarint()
+	if value > math.MaxInt32 {
+		d.fail("value %d is out of range", value)
+		return 0
+	}
+
+	return int(value)
+}
+
+// count reads the number of elements that follow, each of which takes at least one byte.
+func (d *decoder) count() int {
+	n := d.int()
+	if n > len(d.data) {
+		d.fail("count %d exceeds the remaining %d bytes", n, len(d.data))
+		return 0
+	}
+
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+
+	return b
+}
+
+func (d *decoder) strings() []string {
+	strings := make([]string, d.count())
+	for i := range strings {
+		strings[i] = string(d.bytes())
+	}
+
+	return strings
+}
+
+func (d *decoder) instructions() code.Instructions {
+	return code.Instructions(d.bytes())
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	sourceMap := code.SourceMap{}
+
+	for i := d.count(); i > 0; i-- {
+		offset := d.int()
+		pos := token.Position{File: string(d.bytes()), Line: d.int(), Column: d.int()}
+		sourceMap[offset] = pos
+	}
+
+	return sourceMap
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+	case tagBigInteger:
+		negative := d.byte() == 1
+		value := new(big.Int).SetBytes(d.bytes())
+		if negative {
+			value.Neg(value)
+		}
+		return object.NewBigInteger(value)
+	case tagFloat:
+		var bits uint64
+		for i := 0; i < 8; i++ {
+			bits = bits<<8 | uint64(d.byte())
+		}
+		return &object.Float{Value: math.Float64frombits(bits)}
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+	case tagCompiledFunction:
+		fn := &object.CompiledFunction{NumLocals: d.int(), NumParameters: d.int(), Locals: d.strings()}
+		fn.Instructions = d.instructions()
+		fn.SourceMap = d.sourceMap()
+
+		if fn.NumParameters > fn.NumLocals || fn.NumLocals > math.MaxUint8+1 {
+			d.fail("compiled function with %d parameters and %d locals", fn.NumParameters, fn.NumLocals)
+		}
+		if len(fn.Locals) > fn.NumLocals {
+			d.fail("compiled function with %d locals and %d names", fn.NumLocals, len(fn.Locals))
+		}
+		return fn
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
+
+// validateInstructions checks that every instruction is defined and complete, that jumps land on an instruction within
+// the instructions, and that every constant, local and builtin an instruction refers to exists, so that the virtual
+// machine only has to check what depends on the values at runtime, e.g. whether a global has been set.
+// The number of locals is that of the function the instructions belong to, the main program has none.
+//
+// The stack is checked as well: no instruction may take more elements off the stack than the instructions before it
+// left there, every path to an instruction has to leave the stack at the same depth, functions have to return rather
+// than run out of instructions, and the main program has to leave the stack empty.
+func validateInstructions(ins code.Instructions, constants []object.Object, numLocals int, main bool) error {
+	type instruction struct {
+		offset   int
+		op       code.Opcode
+		name     string
+		operands []int
+		next     int
+	}
+
+	instructions := map[int]instruction{}
+	offsets := []int{}
+
+	for i := 0; i < len(ins); {
+		definition, err := code.Lookup(ins[i])
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", i, err)
+		}
+
+		var width int
+		for _, w := range definition.OperandWidths {
+			width += w
+		}
+
+		if i+1+width > len(ins) {
+			return fmt.Errorf("offset %d: %s is missing its operands", i, definition.Name)
+		}
+
+		operands, offset := code.ReadOperands(definition, ins[i+1:])
+		op := code.Opcode(ins[i])
+
+		switch op {
+		case code.OpConstant:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d does not exist", i, operands[0])
+			}
+		case code.OpClosure:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d does not exist", i, operands[0])
+			}
+			if _, ok := constants[operands[0]].(*object.CompiledFunction); !ok {
+				return fmt.Errorf("offset %d: constant %d is not a function", i, operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal, code.OpEmptyCell:
+			if operands[0] >= numLocals {
+				return fmt.Errorf("offset %d: local %d does not exist", i, operands[0])
+			}
+		case code.OpGetBuiltin:
+			if operands[0] >= len(object.Builtins) {
+				return fmt.Errorf("offset %d: builtin %d does not exist", i, operands[0])
+			}
+		case code.OpHash:
+			if operands[0]%2 != 0 {
+				return fmt.Errorf("offset %d: hash with %d keys and values", i, operands[0])
+			}
+		case code.OpReturn, code.OpCurrentClosure:
+			if main {
+				return fmt.Errorf("offset %d: %s outside of a function", i, definition.Name)
+			}
+		}
+
+		instructions[i] = instruction{offset: i, op: op, name: definition.Name, operands: operands, next: i + 1 + offset}
+		offsets = append(offsets, i)
+		i += 1 + offset
+	}
+
+	// Jumps are checked once every instruction is known since they can jump forward
+	for _, offset := range offsets {
+		instruction := instructions[offset]
+		if instruction.op != code.OpJump && instruction.op != code.OpJumpNotTruthy {
+			continue
+		}
+
+		if _, ok := instructions[instruction.operands[0]]; !ok && instruction.operands[0] != len(ins) {
+			return fmt.Errorf("offset %d: jump to %d is not the start of an instruction", instruction.offset, instruction.operands[0])
+		}
+	}
+
+	// depths represents the depth of the stack before each instruction that can be reached from the first one
+	depths := map[int]int{}
+	pending := []int{}
+
+	enter := func(from, offset, depth int) error {
+		if offset == len(ins) {
+			if !main {
+				return fmt.Errorf("offset %d: function does not return", from)
+			}
+			if depth != 0 {
+				return fmt.Errorf("offset %d: main program ends with a stack depth of %d", from, depth)
+			}
+			return nil
+		}
+
+		if previous, ok := depths[offset]; ok {
+			if previous != depth {
+				return fmt.Errorf("offset %d: stack depth %d does not match %d", offset, depth, previous)
+			}
+			return nil
+		}
+
+		depths[offset] = depth
+		pending = append(pending, offset)
+		return nil
+	}
+
+	if err := enter(0, 0, 0); err != nil {
+		return err
+	}
+
+	for len(pending) != 0 {
+		instruction := instructions[pending[len(pending)-1]]
+		pending = pending[:len(pending)-1]
+
+		depth := depths[instruction.offset]
+		pops, pushes := stackEffect(instruction.op, instruction.operands)
+		if pops > depth {
+			return fmt.Errorf(
+				"offset %d: stack underflow, %s needs %d elements but the stack depth is %d",
+				instruction.offset,
+				instruction.name,
+				pops,
+				depth,
+			)
+		}
+		depth += pushes - pops
+
+		var err error
+		switch instruction.op {
+		case code.OpReturn, code.OpReturnValue:
+		case code.OpJump:
+			err = enter(instruction.offset, instruction.operands[0], depth)
+		case code.OpJumpNotTruthy:
+			if err = enter(instruction.offset, instruction.operands[0], depth); err == nil {
+				err = enter(instruction.offset, instruction.next, depth)
+			}
+		default:
+			err = enter(instruction.offset, instruction.next, depth)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stackEffect gets the number of elements an instruction takes off the stack and the number it pushes onto the stack.
+func stackEffect(op code.Opcode, operands []int) (int, int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal, code.OpGetLocal, code.OpGetFree,
+		code.OpGetBuiltin, code.OpCurrentClosure:
+		return 0, 1
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpJumpNotTruthy, code.OpReturnValue:
+		return 1, 0
+	case code.OpBang, code.OpMinus, code.OpCell, code.OpDeref:
+		return 1, 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow, code.OpEQ, code.OpNEQ, code.OpGT,
+		code.OpGTE, code.OpLT, code.OpLTE, code.OpIndex:
+		return 2, 1
+	case code.OpSetCell:
+		return 2, 0
+	case code.OpSetIndex:
+		return 3, 1
+	case code.OpArray, code.OpHash, code.OpConcat:
+		return operands[0], 1
+	case code.OpCall:
+		// The function being called is below its arguments
+		return operands[0] + 1, 1
+	case code.OpClosure:
+		return operands[1], 1
+	case code.OpDup:
+		return operands[0], 2 * operands[0]
+	default:
+		return 0, 0
+	}
+}
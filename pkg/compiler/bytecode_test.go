@@ -0,0 +1,201 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grantwforsythe/monkeylang/pkg/code"
+	"github.com/grantwforsythe/monkeylang/pkg/lexer"
+	"github.com/grantwforsythe/monkeylang/pkg/object"
+	"github.com/grantwforsythe/monkeylang/pkg/parser"
+)
+
+func TestByteCodeRoundTrip(t *testing.T) {
+	tests := []string{
+		"",
+		"1 + 2; -9223372036854775807 - 1",
+		"99999999999999999999999 - 1; -99999999999999999999999",
+		"1.5 + 0.25; 1e308",
+		`"Hello, " + "World!"; "${1} héllo \u{1F412}"`,
+		"let add = fn(x, y) { x + y }; add(1, 2)",
+		"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c()",
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10)",
+	}
+
+	for _, input := range tests {
+		program := parser.New(lexer.NewWithFile("test.monkey", input)).ParseProgram()
+
+		comp := New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.ByteCode()
+
+		data, err := bytecode.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary(%q) failed: %s", input, err)
+		}
+
+		// The source map is a map so it has to be written out in a fixed order to produce the same bytes every time
+		again, _ := bytecode.MarshalBinary()
+		if !bytes.Equal(data, again) {
+			t.Errorf("MarshalBinary(%q) is not deterministic", input)
+		}
+
+		decoded := &ByteCode{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary(%q) failed: %s", input, err)
+		}
+
+		if !reflect.DeepEqual(bytecode, decoded) {
+			t.Errorf("round trip of %q changed the bytecode.\nwant=%+v\ngot= %+v", input, bytecode, decoded)
+		}
+	}
+}
+
+func TestByteCodeUnmarshalErrors(t *testing.T) {
+	comp := New()
+	if err := comp.Compile(parse(`let s = "monkey"; s + 1`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	valid, err := comp.ByteCode().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+
+	corrupt := bytes.Clone(valid)
+	corrupt[len(corrupt)/2] ^= 0xFF
+
+	newVersion := bytes.Clone(valid)
+	newVersion[len(ByteCodeMagic)+1]++
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", []byte{}, "not a Monkey bytecode file"},
+		{"source code", []byte(`let s = "monkey"; s + 1`), "not a Monkey bytecode file"},
+		{"version", newVersion, "unsupported bytecode version 3, expected 2"},
+		{"corrupt", corrupt, "bytecode checksum mismatch, the file is corrupt"},
+		{"truncated", valid[:len(valid)-1], "bytecode checksum mismatch, the file is corrupt"},
+		{"unknown opcode", withChecksum(t, []byte{0, 1, 255, 0, 0}), "malformed bytecode: offset 0: opcode 255 is not defined"},
+		{"missing operands", withChecksum(t, []byte{0, 2, byte(code.OpConstant), 0, 0, 0}), "malformed bytecode: offset 0: OpConstant is missing its operands"},
+		{"missing constant", withChecksum(t, []byte{0, 3, byte(code.OpConstant), 0, 0, 0, 0}), "malformed bytecode: offset 0: constant 0 does not exist"},
+		{"missing builtin", withChecksum(t, []byte{0, 2, byte(code.OpGetBuiltin), 200, 0, 0}), "malformed bytecode: offset 0: builtin 200 does not exist"},
+		{"local in main", withChecksum(t, []byte{0, 2, byte(code.OpGetLocal), 0, 0, 0}), "malformed bytecode: offset 0: local 0 does not exist"},
+		{"return from main", withChecksum(t, []byte{0, 1, byte(code.OpReturn), 0, 0}), "malformed bytecode: offset 0: OpReturn outside of a function"},
+		{"jump into an operand", withChecksum(t, []byte{0, 3, byte(code.OpJump), 0, 1, 0, 0}), "malformed bytecode: offset 0: jump to 1 is not the start of an instruction"},
+		{
+			"stack underflow",
+			withChecksum(t, []byte{0, 2, byte(code.OpTrue), byte(code.OpAdd), 0, 0}),
+			"malformed bytecode: offset 1: stack underflow, OpAdd needs 2 elements but the stack depth is 1",
+		},
+		{
+			"value left on the stack",
+			withChecksum(t, []byte{0, 5, byte(code.OpGetGlobal), 0, 5, byte(code.OpTrue), byte(code.OpAdd), 0, 0}),
+			"malformed bytecode: offset 4: main program ends with a stack depth of 1",
+		},
+		{
+			"stack depth mismatch",
+			withChecksum(t, []byte{0, 7, byte(code.OpTrue), byte(code.OpJumpNotTruthy), 0, 5, byte(code.OpTrue), byte(code.OpNull), byte(code.OpPop), 0, 0}),
+			"malformed bytecode: offset 5: stack depth 1 does not match 0",
+		},
+		{
+			"function without a return",
+			withChecksum(t, []byte{1, tagCompiledFunction, 0, 0, 0, 1, byte(code.OpNull), 0, 0, 0, 0}),
+			"malformed bytecode: constant 0: offset 0: function does not return",
+		},
+		{"unknown tag", withChecksum(t, []byte{1, 99, 0, 0}), "malformed bytecode: unknown constant tag 99"},
+		{
+			"names of missing locals",
+			withChecksum(t, []byte{1, tagCompiledFunction, 0, 0, 1, 1, 'a', 1, byte(code.OpReturn), 0, 0, 0, 0}),
+			"malformed bytecode: compiled function with 0 locals and 1 names",
+		},
+		{"trailing bytes", withChecksum(t, []byte{0, 0, 0, 0, 0}), "malformed bytecode: 1 unexpected bytes after the globals"},
+	}
+
+	for _, tt := range tests {
+		err := (&ByteCode{}).UnmarshalBinary(tt.data)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err.Error())
+		}
+	}
+}
+
+// FuzzByteCodeUnmarshalBinary checks the decoder on its own, FuzzRunByteCode in the vm package runs what it decodes.
+func FuzzByteCodeUnmarshalBinary(f *testing.F) {
+	for _, input := range []string{"1 + 2.5", `let f = fn(x) { "${x}" }; f(2 ** 70)`, "0.5"} {
+		comp := New()
+		if err := comp.Compile(parse(input)); err != nil {
+			f.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.ByteCode()
+		// NaN is not equal to itself, which a comparison of the decoded constants would trip over
+		if input == "0.5" {
+			bytecode.Constants[0] = &object.Float{Value: math.NaN()}
+		}
+
+		data, err := bytecode.MarshalBinary()
+		if err != nil {
+			f.Fatalf("MarshalBinary failed: %s", err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// The checksum would reject almost every mutation so it is recomputed to exercise the decoder itself
+		if len(data) >= len(ByteCodeMagic)+6 && strings.HasPrefix(string(data), ByteCodeMagic) {
+			data = withChecksum(t, data[len(ByteCodeMagic)+2:len(data)-4])
+		}
+
+		bytecode := &ByteCode{}
+		if err := bytecode.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		// Anything that decodes successfully has to survive another round trip unchanged. The input itself may differ
+		// from its encoding because e.g. a varint can be encoded in more than one way, so the encodings are compared.
+		data, err := bytecode.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %s", err)
+		}
+
+		decoded := &ByteCode{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary failed after a round trip: %s", err)
+		}
+
+		reencoded, err := decoded.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed after a round trip: %s", err)
+		}
+
+		if !bytes.Equal(data, reencoded) {
+			t.Fatalf("round trip changed the bytecode.\nwant=%+v\ngot= %+v", bytecode, decoded)
+		}
+	})
+}
+
+// withChecksum wraps the body of a serialized program, i.e. everything after the version, with a valid header and
+// checksum.
+func withChecksum(t *testing.T, body []byte) []byte {
+	t.Helper()
+
+	data := append([]byte(ByteCodeMagic), 0, ByteCodeVersion)
+	data = append(data, body...)
+
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
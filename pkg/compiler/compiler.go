@@ -3,15 +3,50 @@ package compiler
 
 import (
 	"fmt"
+	"math"
+	"sort"
 
 	"github.com/grantwforsythe/monkeylang/pkg/ast"
 	"github.com/grantwforsythe/monkeylang/pkg/code"
 	"github.com/grantwforsythe/monkeylang/pkg/object"
+	"github.com/grantwforsythe/monkeylang/pkg/token"
 )
 
 type Compiler struct {
-	instructions code.Instructions
-	constants    []object.Object
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope // scopes represents a stack of scopes, one for the main program and one for each function being compiled.
+	scopeIndex int                // scopeIndex represents the index of the current scope.
+
+	position token.Position // position represents the position of the innermost node being compiled.
+
+	// err represents the first operand that was too large for the instruction it was emitted with, see checkOperands.
+	// It is returned once the node being compiled is done rather than by every call to emit.
+	err error
+}
+
+// CompilationScope represents the instructions emitted for a single function body or the main program.
+type CompilationScope struct {
+	instructions        code.Instructions
+	sourceMap           code.SourceMap     // sourceMap maps each emitted instruction to the position of the node it was compiled from.
+	lastInstruction     EmittedInstruction // lastInstruction is the most recently emitted instruction.
+	previousInstruction EmittedInstruction // previousInstruction is the instruction emitted before lastInstruction.
+	loops               []*Loop            // loops represents a stack of the loops enclosing the instruction being emitted.
+	depth               int                // depth represents the number of elements the emitted instructions leave on the stack.
+}
+
+// Loop represents the jumps out of a loop being compiled, their targets are back-patched once the loop has been compiled.
+type Loop struct {
+	Breaks    []int // Breaks represents the positions of the jumps emitted for break statements.
+	Continues []int // Continues represents the positions of the jumps emitted for continue statements.
+	Depth     int   // Depth represents the number of elements on the stack when the body of the loop is entered.
+}
+
+// EmittedInstruction represents an instruction that has been emitted by the compiler.
+type EmittedInstruction struct {
+	Opcode   code.Opcode // Opcode represents the opcode of the emitted instruction.
+	Position int         // Position represents the starting position of the instruction.
 }
 
 // ByteCode represents a domain-specific language for a domain-specific virtual machine.
@@ -19,18 +54,60 @@ type Compiler struct {
 type ByteCode struct {
 	Instructions code.Instructions // Instructions represent the instructions generated by the compiler.
 	Constants    []object.Object   // Constants represent the constants generated by the compiler.
+	SourceMap    code.SourceMap    // SourceMap maps the instructions of the main program to their positions in the source code.
+	Globals      []string          // Globals represent the names of the global bindings by index.
+}
+
+// Error represents an error that stopped a program from being compiled.
+type Error struct {
+	Message string         // Message represents the reason the program could not be compiled.
+	Pos     token.Position // Pos represents the position in the source code of the node that could not be compiled.
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
 }
 
 // New initializes a new compiler.
 func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		sourceMap:           code.SourceMap{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
 	return &Compiler{
-		instructions: code.Instructions{},
-		constants:    []object.Object{}, // constants is a global pool for all constants.
+		constants:   []object.Object{}, // constants is a global pool for all constants.
+		symbolTable: NewSymbolTableWithBuiltins(),
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
 	}
 }
 
+// NewWithState initializes a new compiler which reuses the symbol table and constants of a previous compilation.
+// This allows global bindings to survive between compilations, e.g. between lines in the REPL.
+// The builtins are expected to already be defined in the symbol table, see NewSymbolTableWithBuiltins.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
 // Compile traverses the nodes in the AST, converting it into bytecode.
+// Returns an *Error if the node could not be compiled.
 func (c *Compiler) Compile(node ast.Node) error {
+	// Instructions are attributed to the innermost node with a known position, e.g. nodes created by macros have none.
+	if pos := node.Pos(); pos.IsValid() {
+		outer := c.position
+		c.position = pos
+		defer func() { c.position = outer }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, stmt := range node.Statements {
@@ -49,30 +126,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpPop)
 
 	case *ast.InfixExpression:
-		// We are using one op for both greater than and less than, all that changes is the order in which values are emitted
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
 
-			c.emit(code.OpGT)
-			return nil
-		} else {
-			err := c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
+		// The operands are always evaluated from left to right, the same as the evaluator, so that side effects and
+		// errors happen in the same order
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
 
-			err = c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
 		}
 
 		switch node.Operator {
@@ -84,16 +151,238 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case "**":
+			c.emit(code.OpPow)
 		case "==":
 			c.emit(code.OpEQ)
 		case "!=":
 			c.emit(code.OpNEQ)
 		case ">":
 			c.emit(code.OpGT)
+		case ">=":
+			c.emit(code.OpGTE)
+		case "<":
+			c.emit(code.OpLT)
+		case "<=":
+			c.emit(code.OpLTE)
 		default:
-			return fmt.Errorf("unknown operator: %s", node.Operator)
+			return c.newError(node, "unknown operator: %s", node.Operator)
 		}
 
+	case *ast.IfExpression:
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		// The operand is a placeholder that is back-patched once the consequence has been compiled.
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		depth := c.scopes[c.scopeIndex].depth
+
+		err = c.Compile(node.Consequence)
+		if err != nil {
+			return err
+		}
+
+		// The value of the consequence needs to stay on the stack since conditionals are expressions.
+		// A consequence which does not end in an expression, e.g. one that is empty or ends with a break, evaluates to null.
+		if c.lastInstructionIs(code.OpPop) {
+			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
+		}
+
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		// The alternative is only reached without the value of the consequence on the stack
+		c.scopes[c.scopeIndex].depth = depth
+
+		if node.Alternative == nil {
+			// A conditional without an alternative evaluates to null when the condition is not truthy.
+			c.emit(code.OpNull)
+		} else {
+			err := c.Compile(node.Alternative)
+			if err != nil {
+				return err
+			}
+
+			if c.lastInstructionIs(code.OpPop) {
+				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
+			}
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			err := c.Compile(stmt)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		// A function bound to a global or a boxed local has to refer to itself through that binding, which may be assigned
+		// another value later, so the name is defined before the function is compiled.
+		var symbol Symbol
+		fn, ok := node.Value.(*ast.FunctionLiteral)
+		selfReference := ok && fn.Name == node.Name.Value &&
+			(c.symbolTable.Outer == nil || c.symbolTable.boxed[node.Name.Value])
+		if selfReference {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
+
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if !selfReference {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
+
+		// The cell of a boxed local is created when the function is entered so the value is stored in it like an assignment
+		c.storeSymbol(symbol)
+
+	case *ast.AssignExpression:
+		return c.compileAssignment(node)
+
+	case *ast.WhileStatement:
+		return c.compileLoop(nil, node.Condition, nil, node.Body)
+
+	case *ast.ForStatement:
+		return c.compileLoop(node.Init, node.Condition, node.Post, node.Body)
+
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.newError(node, "break outside of loop")
+		}
+
+		// The target is back-patched to the end of the loop once it is known.
+		loop.Breaks = append(loop.Breaks, c.jumpOutOfLoop(loop))
+
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.newError(node, "continue outside of loop")
+		}
+
+		// The target is back-patched to the post statement of the loop once it is known.
+		loop.Continues = append(loop.Continues, c.jumpOutOfLoop(loop))
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return c.newError(node, "identifier not found: %s", node.Value)
+		}
+
+		c.loadSymbol(symbol)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+		boxed := boxedLocals(node)
+		c.symbolTable.Box(boxed)
+
+		// Defining the name of the function inside of its own scope allows it to reference itself, i.e. recursion, unless
+		// the name is already bound to a global or boxed local which has to be resolved instead.
+		symbol, ok := c.symbolTable.Outer.store[node.Name]
+		if node.Name != "" && !(ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope)) {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, param := range node.Parameters {
+			symbol := c.symbolTable.Define(param.Value)
+
+			// The arguments are pushed as plain values so a boxed parameter is moved into a cell before the body runs.
+			if symbol.Boxed {
+				c.emit(code.OpGetLocal, symbol.Index)
+				c.emit(code.OpCell)
+				c.emit(code.OpSetLocal, symbol.Index)
+			}
+			delete(boxed, param.Value)
+		}
+
+		// Every closure created while the function runs has to share the same cell, even if the let statement defining
+		// the local runs more than once, e.g. in a loop, so the cells of the remaining boxed locals are created up front.
+		names := make([]string, 0, len(boxed))
+		for name := range boxed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			c.emit(code.OpEmptyCell, c.symbolTable.Reserve(name))
+		}
+
+		err := c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		// The last expression in a function body is implicitly returned.
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+
+		// A function with an empty body, or one that ends in a statement, returns null.
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		locals := c.symbolTable.names()
+		// Parameters which are never used are not referred to by any instruction, so they have to be counted here
+		if numLocals > math.MaxUint8+1 {
+			return c.operandError(code.OpGetLocal, 0)
+		}
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
+		instructions := c.leaveScope()
+
+		// Push the free variables onto the stack so they can be captured by the closure.
+		for _, symbol := range freeSymbols {
+			c.captureSymbol(symbol)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			SourceMap:     sourceMap,
+			Locals:        locals,
+		}
+
+		c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpReturnValue)
+
+	case *ast.CallExpression:
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+
+		for _, arg := range node.Arguments {
+			err := c.Compile(arg)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpCall, len(node.Arguments))
+
 	case *ast.BooleanExpression:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -102,10 +391,77 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		integer := &object.Integer{Value: node.Value, Big: node.Big}
 		// The index of the newly added constant is used as an operand in the emitted instruction.
 		c.emit(code.OpConstant, c.addConstant(integer))
 
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			err := c.Compile(part)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpConcat, len(node.Parts))
+
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			err := c.Compile(element)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for key := range node.Pairs {
+			keys = append(keys, key)
+		}
+
+		// Go does not guarantee the iteration order of a map so the keys are sorted to emit the same instructions every time.
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, key := range keys {
+			err := c.Compile(key)
+			if err != nil {
+				return err
+			}
+
+			err = c.Compile(node.Pairs[key])
+			if err != nil {
+				return err
+			}
+		}
+
+		// The operand is the number of keys and values that are on the stack.
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexEpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpIndex)
+
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
 		if err != nil {
@@ -118,15 +474,369 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return c.newError(node, "unknown operator %s", node.Operator)
 		}
 
 	}
 
 	// Iterate over the instructions in memory, repeating the fetch-decode-execute cycle like in an actual machine.
+	return c.err
+}
+
+// compileLoop compiles a loop which executes its body for as long as the condition is truthy, or until it breaks.
+// The init and post statements as well as the condition are optional. Loops are statements so they do not leave a value on the stack.
+func (c *Compiler) compileLoop(init ast.Statement, condition ast.Expression, post ast.Statement, body *ast.BlockStatement) error {
+	if init != nil {
+		err := c.Compile(init)
+		if err != nil {
+			return err
+		}
+	}
+
+	startPos := len(c.currentInstructions())
+
+	exitPos := -1
+	if condition != nil {
+		err := c.Compile(condition)
+		if err != nil {
+			return err
+		}
+
+		// The operand is a placeholder that is back-patched once the end of the loop is known.
+		exitPos = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &Loop{Depth: scope.depth})
+
+	err := c.Compile(body)
+	if err != nil {
+		return err
+	}
+
+	scope = &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	postPos := len(c.currentInstructions())
+	if post != nil {
+		err := c.Compile(post)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpJump, startPos)
+	endPos := len(c.currentInstructions())
+
+	if exitPos != -1 {
+		c.changeOperand(exitPos, endPos)
+	}
+
+	for _, pos := range loop.Breaks {
+		c.changeOperand(pos, endPos)
+	}
+
+	for _, pos := range loop.Continues {
+		c.changeOperand(pos, postPos)
+	}
+
+	return nil
+}
+
+// jumpOutOfLoop emits a jump for a break or continue statement, preceded by the instructions which pop the elements
+// pushed since the body of the loop was entered, e.g. the operands of the expression the statement is nested in.
+// Returns the position of the jump, its target is a placeholder.
+func (c *Compiler) jumpOutOfLoop(loop *Loop) int {
+	depth := c.scopes[c.scopeIndex].depth
+	for i := depth; i > loop.Depth; i-- {
+		c.emit(code.OpPop)
+	}
+
+	position := c.emit(code.OpJump, 9999)
+
+	// The instructions following the jump are only reached with the elements still on the stack
+	c.scopes[c.scopeIndex].depth = depth
+
+	return position
+}
+
+// compileLogicalExpression compiles && and || into conditional jumps so that the right operand is only evaluated when it decides the result.
+// Like the comparison operators, the result is always a boolean.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	// Each result is pushed on its own path so only one of them is ever on the stack
+	depth := c.scopes[c.scopeIndex].depth
+
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	// The operands are placeholders that are back-patched once the positions of the results are known.
+	falsePositions := []int{}
+	endPositions := []int{}
+
+	if node.Operator == "||" {
+		// A truthy left operand decides the result, otherwise it falls through to checking the right operand.
+		rightPos := c.emit(code.OpJumpNotTruthy, 9999)
+		c.emit(code.OpTrue)
+		endPositions = append(endPositions, c.emit(code.OpJump, 9999))
+		c.scopes[c.scopeIndex].depth = depth
+		c.changeOperand(rightPos, len(c.currentInstructions()))
+	} else {
+		falsePositions = append(falsePositions, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	falsePositions = append(falsePositions, c.emit(code.OpJumpNotTruthy, 9999))
+	c.emit(code.OpTrue)
+	endPositions = append(endPositions, c.emit(code.OpJump, 9999))
+	c.scopes[c.scopeIndex].depth = depth
+
+	falsePos := len(c.currentInstructions())
+	c.emit(code.OpFalse)
+	endPos := len(c.currentInstructions())
+
+	for _, pos := range falsePositions {
+		c.changeOperand(pos, falsePos)
+	}
+
+	for _, pos := range endPositions {
+		c.changeOperand(pos, endPos)
+	}
+
+	return nil
+}
+
+// assignOperators maps the operators of compound assignments to the opcodes that combine the current and new values.
+var assignOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+// compileAssignment compiles an assignment to an existing binding, or to an element of an array or hash.
+// The assigned value is left on the stack since assignments are expressions.
+func (c *Compiler) compileAssignment(node *ast.AssignExpression) error {
+	op, compound := assignOperators[node.Operator]
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return c.newError(target, "identifier not found: %s", target.Value)
+		}
+
+		if symbol.Scope == BuiltinScope || symbol.Scope == FunctionScope || (symbol.Scope == FreeScope && !symbol.Boxed) {
+			return c.newError(target, "cannot assign to %s", target.Value)
+		}
+
+		// The current value is read even if it is replaced so that assigning a variable whose let statement did not run
+		// fails, the same as reading it does. It is compiled from the target so that the error points at the name.
+		err := c.Compile(target)
+		if err != nil {
+			return err
+		}
+		if !compound {
+			c.emit(code.OpPop)
+		}
+
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+
+	case *ast.IndexEpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+
+		// The object and index are reused to read the current value so they are only evaluated once.
+		if compound {
+			c.emit(code.OpDup, 2)
+			c.emit(code.OpIndex)
+		}
+
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.emit(code.OpSetIndex)
+
+	default:
+		return c.newError(node.Target, "cannot assign to %s", node.Target.String())
+	}
+
 	return nil
 }
 
+// storeSymbol emits the instructions which pop the top most element off the stack and bind it to an existing symbol.
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch {
+	case s.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case s.Boxed:
+		c.captureSymbol(s)
+		c.emit(code.OpSetCell)
+	default:
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// boxedLocals finds the parameters and locals of a function that have to be boxed, see SymbolTable.Box. These are the
+// ones that a nested function refers to and that are assigned to after they are defined, either by an assignment
+// anywhere in the function or by a let statement that runs more than once, i.e. one in a loop or a redefinition.
+// Shadowing is not taken into account, boxing a local which did not need it is harmless.
+func boxedLocals(fn *ast.FunctionLiteral) map[string]bool {
+	usage := &localUsage{defined: map[string]int{}, captured: map[string]bool{}, assigned: map[string]bool{}}
+	for _, param := range fn.Parameters {
+		usage.defined[param.Value]++
+	}
+	usage.walk(fn.Body, 0, false)
+
+	names := make(map[string]bool)
+	for name := range usage.defined {
+		if usage.captured[name] && usage.assigned[name] {
+			names[name] = true
+		}
+	}
+
+	return names
+}
+
+// localUsage records how the identifiers of a function are used.
+type localUsage struct {
+	defined  map[string]int  // defined represents the number of definitions of each identifier in the function itself.
+	captured map[string]bool // captured represents the identifiers referred to by a nested function.
+	assigned map[string]bool // assigned represents the identifiers assigned to after being defined.
+}
+
+// walk records the usage of the identifiers in a node. The depth is the number of functions the node is nested in
+// relative to the function being analysed, and inLoop reports whether the node may run more than once.
+func (u *localUsage) walk(node ast.Node, depth int, inLoop bool) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if depth > 0 {
+			u.captured[node.Value] = true
+		}
+
+	case *ast.LetStatement:
+		if depth == 0 {
+			u.defined[node.Name.Value]++
+			if inLoop || u.defined[node.Name.Value] > 1 {
+				u.assigned[node.Name.Value] = true
+			}
+		}
+		u.walk(node.Value, depth, inLoop)
+
+	case *ast.AssignExpression:
+		if ident, ok := node.Target.(*ast.Identifier); ok {
+			u.assigned[ident.Value] = true
+		}
+		u.walk(node.Target, depth, inLoop)
+		u.walk(node.Value, depth, inLoop)
+
+	case *ast.FunctionLiteral:
+		u.walk(node.Body, depth+1, false)
+
+	case *ast.WhileStatement:
+		u.walk(node.Condition, depth, true)
+		u.walk(node.Body, depth, true)
+
+	case *ast.ForStatement:
+		// The init statement only runs once
+		u.walk(node.Init, depth, inLoop)
+		u.walk(node.Condition, depth, true)
+		u.walk(node.Post, depth, true)
+		u.walk(node.Body, depth, true)
+
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			u.walk(stmt, depth, inLoop)
+		}
+
+	case *ast.ExpressionStatement:
+		u.walk(node.Expression, depth, inLoop)
+
+	case *ast.ReturnStatement:
+		u.walk(node.ReturnValue, depth, inLoop)
+
+	case *ast.PrefixExpression:
+		u.walk(node.Right, depth, inLoop)
+
+	case *ast.InfixExpression:
+		u.walk(node.Left, depth, inLoop)
+		u.walk(node.Right, depth, inLoop)
+
+	case *ast.IfExpression:
+		u.walk(node.Condition, depth, inLoop)
+		u.walk(node.Consequence, depth, inLoop)
+		if node.Alternative != nil {
+			u.walk(node.Alternative, depth, inLoop)
+		}
+
+	case *ast.CallExpression:
+		u.walk(node.Function, depth, inLoop)
+		for _, arg := range node.Arguments {
+			u.walk(arg, depth, inLoop)
+		}
+
+	case *ast.IndexEpression:
+		u.walk(node.Left, depth, inLoop)
+		u.walk(node.Index, depth, inLoop)
+
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			u.walk(element, depth, inLoop)
+		}
+
+	case *ast.HashLiteral:
+		for key, value := range node.Pairs {
+			u.walk(key, depth, inLoop)
+			u.walk(value, depth, inLoop)
+		}
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			u.walk(part, depth, inLoop)
+		}
+	}
+}
+
+// currentLoop gets the innermost loop being compiled in the current scope.
+// Returns nil if the current instruction is not inside of a loop.
+func (c *Compiler) currentLoop() *Loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+
+	return loops[len(loops)-1]
+}
+
 // addConstant adds a constant to the constants pool.
 // Returns the index of the newly added constant.
 func (c *Compiler) addConstant(obj object.Object) int {
@@ -138,17 +848,195 @@ func (c *Compiler) addConstant(obj object.Object) int {
 // emit generates an instruction and add it to the results.
 // Returns the position of the newly added instruction.
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands...)
 	instruction := code.Make(op, operands...)
 	// Starting position of the newly added instruction.
-	position := len(c.instructions)
+	position := len(c.currentInstructions())
 	// PERF: Unperformant way to add elements to a slice because the cap is 0 by default and will always be x2 the len by default
-	c.instructions = append(c.instructions, instruction...)
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), instruction...)
+	if c.position.IsValid() {
+		c.scopes[c.scopeIndex].sourceMap[position] = c.position
+	}
+
+	c.setLastInstruction(op, position)
+
+	pops, pushes := stackEffect(op, operands)
+	c.scopes[c.scopeIndex].depth += pushes - pops
+
 	return position
 }
 
+// loadSymbol emits the instructions which push the value bound to a symbol onto the stack.
+func (c *Compiler) loadSymbol(s Symbol) {
+	c.captureSymbol(s)
+
+	if s.Boxed {
+		c.emit(code.OpDeref)
+	}
+}
+
+// captureSymbol emits the instruction which pushes the binding of a symbol onto the stack as is, i.e. the cell of a boxed
+// symbol rather than its value, so that a closure capturing it shares the binding with the enclosing function.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// setLastInstruction keeps track of the last two emitted instructions.
+func (c *Compiler) setLastInstruction(op code.Opcode, position int) {
+	c.scopes[c.scopeIndex].previousInstruction = c.scopes[c.scopeIndex].lastInstruction
+	c.scopes[c.scopeIndex].lastInstruction = EmittedInstruction{Opcode: op, Position: position}
+}
+
+// lastInstructionIs checks if the last emitted instruction has the given opcode.
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+// removeLastPop removes the last emitted instruction, which is assumed to be an OpPop.
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	delete(c.scopes[c.scopeIndex].sourceMap, last.Position)
+	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].depth++
+}
+
+// replaceLastPopWithReturn replaces the last emitted instruction, which is assumed to be an OpPop, with an OpReturnValue.
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPosition := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPosition, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+// replaceInstruction overwrites the instruction at the given position with a new instruction.
+// The new instruction is expected to have the same width as the one being replaced.
+func (c *Compiler) replaceInstruction(position int, instruction code.Instructions) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(instruction); i++ {
+		ins[position+i] = instruction[i]
+	}
+}
+
+// changeOperand replaces the operand of the instruction at the given position.
+// This is used to back-patch the target of a jump once it is known.
+func (c *Compiler) changeOperand(position int, operand int) {
+	op := code.Opcode(c.currentInstructions()[position])
+	c.checkOperands(op, operand)
+	instruction := code.Make(op, operand)
+
+	c.replaceInstruction(position, instruction)
+}
+
+// newError creates an error positioned at the given node, or at the innermost node being compiled if it has no
+// position, e.g. it was created by a macro or the error is not caused by a particular node.
+func (c *Compiler) newError(node ast.Node, format string, a ...any) *Error {
+	pos := c.position
+	if node != nil && node.Pos().IsValid() {
+		pos = node.Pos()
+	}
+	return &Error{Message: fmt.Sprintf(format, a...), Pos: pos}
+}
+
+// checkOperands records an error if an operand does not fit in the width the instruction has for it, code.Make would
+// silently truncate it otherwise. Only the first error is kept since the others are usually caused by the same node.
+func (c *Compiler) checkOperands(op code.Opcode, operands ...int) {
+	definition, err := code.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+
+	for i, operand := range operands {
+		if i < len(definition.OperandWidths) && operand >= 1<<(8*definition.OperandWidths[i]) {
+			c.err = c.operandError(op, i)
+			return
+		}
+	}
+}
+
+// operandError describes the limit of the virtual machine that a program exceeds when the operand at the given index
+// of an instruction does not fit.
+func (c *Compiler) operandError(op code.Opcode, index int) *Error {
+	switch {
+	case op == code.OpGetLocal || op == code.OpSetLocal:
+		return c.newError(nil, "too many locals: more than %d in one function", math.MaxUint8+1)
+	case op == code.OpGetFree || op == code.OpClosure && index == 1:
+		return c.newError(nil, "too many free variables: more than %d in one function", math.MaxUint8)
+	case op == code.OpGetGlobal || op == code.OpSetGlobal:
+		return c.newError(nil, "too many globals: more than %d", math.MaxUint16+1)
+	case op == code.OpConstant || op == code.OpClosure:
+		return c.newError(nil, "too many constants: more than %d", math.MaxUint16+1)
+	case op == code.OpCall:
+		return c.newError(nil, "too many arguments: more than %d in one call", math.MaxUint8)
+	case op == code.OpArray:
+		return c.newError(nil, "array literal too long: more than %d elements", math.MaxUint16)
+	case op == code.OpHash:
+		return c.newError(nil, "hash literal too long: more than %d pairs", math.MaxUint16/2)
+	case op == code.OpConcat:
+		return c.newError(nil, "interpolated string too long: more than %d parts", math.MaxUint16)
+	case op == code.OpJump || op == code.OpJumpNotTruthy:
+		return c.newError(nil, "function too long: more than %d bytes of instructions", math.MaxUint16)
+	default:
+		return c.newError(nil, "operand %d of opcode %d is too large", index, op)
+	}
+}
+
+// currentInstructions gets the instructions of the current scope.
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+// enterScope creates a new scope, along with an enclosed symbol table, for compiling a function body.
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		sourceMap:           code.SourceMap{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// leaveScope removes the current scope and restores the enclosing symbol table.
+// Returns the instructions emitted in the removed scope.
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
 func (c *Compiler) ByteCode() *ByteCode {
 	return &ByteCode{
-		Instructions: c.instructions,
+		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		Globals:      c.symbolTable.names(),
 	}
 }
@@ -2,6 +2,8 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/grantwforsythe/monkeylang/pkg/ast"
@@ -9,6 +11,7 @@ import (
 	"github.com/grantwforsythe/monkeylang/pkg/lexer"
 	"github.com/grantwforsythe/monkeylang/pkg/object"
 	"github.com/grantwforsythe/monkeylang/pkg/parser"
+	"github.com/grantwforsythe/monkeylang/pkg/token"
 )
 
 type compilerTestCase struct {
@@ -101,13 +104,1045 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			"1.5 * 2",
+			[]any{1.5, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"5 % 2",
+			[]any{5, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"2 ** 3",
+			[]any{2, 3},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPow),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			"1; 2",
 			[]any{1, 2},
 			[]code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBooleanExpression(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			"true; false",
+			[]any{},
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"false",
+			[]any{},
+			[]code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"1 > 2",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGT),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"1 < 2",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLT),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"1 == 2",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEQ),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"1 != 2",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNEQ),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"true == true",
+			[]any{},
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpTrue),
+				code.Make(code.OpEQ),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"false == true",
+			[]any{},
+			[]code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpTrue),
+				code.Make(code.OpEQ),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"true != true",
+			[]any{},
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpTrue),
+				code.Make(code.OpNEQ),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"!true",
+			[]any{},
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"!true != true",
+			[]any{},
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpTrue),
+				code.Make(code.OpNEQ),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"!(1 != 2)",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNEQ),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"1 >= 2",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGTE),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"1 <= 2",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLTE),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"true && false",
+			[]any{},
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpFalse),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"true || false",
+			[]any{},
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 17),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpJump, 17),
+				// 0016
+				code.Make(code.OpFalse),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			"if (true) { 10 }; 3333;",
+			[]any{10, 3333},
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"if (true) { 10 } else { 20 }; 3333;",
+			[]any{10, 20, 3333},
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 13),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			"while (true) { 10 }; 3333;",
+			[]any{10, 3333},
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpConstant, 1),
+				// 0014
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"for (let i = 0; i < 10; let i = i + 1) { if (i == 5) { break; }; continue; }",
+			[]any{0, 10, 5, 1},
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpLT),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 51),
+				// 0016
+				code.Make(code.OpGetGlobal, 0),
+				// 0019
+				code.Make(code.OpConstant, 2),
+				// 0022
+				code.Make(code.OpEQ),
+				// 0023
+				code.Make(code.OpJumpNotTruthy, 33),
+				// 0026
+				code.Make(code.OpJump, 51),
+				// 0029
+				code.Make(code.OpNull),
+				// 0030
+				code.Make(code.OpJump, 34),
+				// 0033
+				code.Make(code.OpNull),
+				// 0034
+				code.Make(code.OpPop),
+				// 0035
+				code.Make(code.OpJump, 38),
+				// 0038
+				code.Make(code.OpGetGlobal, 0),
+				// 0041
+				code.Make(code.OpConstant, 3),
+				// 0044
+				code.Make(code.OpAdd),
+				// 0045
+				code.Make(code.OpSetGlobal, 0),
+				// 0048
+				code.Make(code.OpJump, 6),
+			},
+		},
+		{
+			// The element already on the stack is popped before breaking out of the array literal
+			"while (true) { [1, if (true) { break; }] }",
+			[]any{1},
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 27),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpTrue),
+				// 0008
+				code.Make(code.OpJumpNotTruthy, 19),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpJump, 27),
+				// 0015
+				code.Make(code.OpNull),
+				// 0016
+				code.Make(code.OpJump, 20),
+				// 0019
+				code.Make(code.OpNull),
+				// 0020
+				code.Make(code.OpArray, 2),
+				// 0023
+				code.Make(code.OpPop),
+				// 0024
+				code.Make(code.OpJump, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// The variable is read first so that assigning it fails if its let statement did not run.
+			"let x = 1; x = 2;",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"let x = 1; x += 2;",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"let arr = [1]; arr[0] -= 2;",
+			[]any{1, 0, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSub),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// c is boxed because the inner function assigns to it, the inner function captures the cell rather than its value.
+			// The cell is created when the function is entered and the let statement stores its value in it.
+			"fn() { let c = 0; fn() { c = 1 }; c }",
+			[]any{
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpSetCell),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpEmptyCell, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetCell),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Parameters arrive as plain values so a boxed parameter is moved into a cell first.
+			"fn(a) { fn() { a = 1 } }",
+			[]any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpSetCell),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCell),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// x is boxed because the function reassigns it after the inner function captured it.
+			"fn() { let x = 1; fn() { x }; x = 2 }",
+			[]any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpReturnValue),
+				},
+				2,
+				[]code.Instructions{
+					code.Make(code.OpEmptyCell, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetCell),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetCell),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBoxedLocals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fn() { let x = 1; fn() { x } }", []string{}},
+		{"fn() { let x = 1; x = 2 }", []string{}},
+		{"fn() { let x = 1; fn() { x }; x = 2 }", []string{"x"}},
+		{"fn() { let x = 1; fn() { x = 2 } }", []string{"x"}},
+		{"fn(a) { fn() { a }; a += 1 }", []string{"a"}},
+		{"fn() { let x = 1; fn() { x }; let x = 2 }", []string{"x"}},
+		{"fn() { while (true) { let y = 1; fn() { y } } }", []string{"y"}},
+		{"fn() { for (let i = 0; i < 3; i += 1) { fn() { i } } }", []string{"i"}},
+		// Shadowing is not taken into account, the inner x is a different binding but the outer one is boxed anyway
+		{"fn() { let x = 1; fn() { let x = 2; x = 3 } }", []string{"x"}},
+	}
+
+	for _, tt := range tests {
+		fn := parse(tt.input).Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+
+		boxed := boxedLocals(fn)
+		if len(boxed) != len(tt.expected) {
+			t.Errorf("%s: wrong boxed locals. want=%v, got=%v", tt.input, tt.expected, boxed)
+			continue
+		}
+
+		for _, name := range tt.expected {
+			if !boxed[name] {
+				t.Errorf("%s: %s is not boxed. got=%v", tt.input, name, boxed)
+			}
+		}
+	}
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1", "1:1: identifier not found: x"},
+		{"len = 1", "1:1: cannot assign to len"},
+		{"let a = 1;\nwhile (a) {\n  a += b;\n}", "3:8: identifier not found: b"},
+		{"fn() {\n  len = 1\n}", "2:3: cannot assign to len"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q, got none", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	// identifiers creates distinct names out of letters, which are all that identifiers may contain
+	identifiers := func(n int) []string {
+		names := make([]string, n)
+		for i := range names {
+			names[i] = fmt.Sprintf("v%c%c", 'a'+i/26%26, 'a'+i%26)
+		}
+		return names
+	}
+
+	lets := func(names []string) string {
+		return "let " + strings.Join(names, " = 1; let ") + " = 1;"
+	}
+
+	repeat := func(s string, n int) string {
+		return strings.TrimSuffix(strings.Repeat(s+", ", n), ", ")
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { " + lets(identifiers(256)) + " }", ""},
+		{"fn() { " + lets(identifiers(300)) + " }", "too many locals: more than 256 in one function"},
+		{"fn(" + strings.Join(identifiers(257), ", ") + ") { 1 }", "too many locals: more than 256 in one function"},
+		{"fn() { " + lets(identifiers(256)) + " fn() { [" + strings.Join(identifiers(255), ", ") + "] } }", ""},
+		{
+			"fn() { " + lets(identifiers(256)) + " fn() { [" + strings.Join(identifiers(256), ", ") + "] } }",
+			"too many free variables: more than 255 in one function",
+		},
+		{"len(" + repeat("true", 255) + ")", ""},
+		{"len(" + repeat("true", 256) + ")", "too many arguments: more than 255 in one call"},
+		{"[" + repeat("1", 65535) + "]; 1", ""},
+		{"[" + repeat("1", 65535) + "]; 1; 1", "too many constants: more than 65536"},
+		{"[" + repeat("true", 65536) + "]", "array literal too long: more than 65535 elements"},
+		{"if (true) { " + strings.Repeat("true; ", 33000) + "}", "function too long: more than 65535 bytes of instructions"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+
+		err := compiler.Compile(parse(tt.input))
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected compiler error for %.40q: %s", tt.input, err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("expected compiler error for %.40q, got none", tt.input)
+			continue
+		}
+
+		// The position depends on which node exceeds the limit first, see TestCompilerErrorPositions
+		compilerErr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("error is not *Error. got=%T (%+v)", err, err)
+			continue
+		}
+
+		if compilerErr.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, compilerErr.Message)
+		}
+	}
+}
+
+func TestCompilerErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected token.Position
+	}{
+		{"1;\n  foobar", token.Position{Line: 2, Column: 3}},
+		{"let f = fn() {\n\treturn [1, x];\n};", token.Position{Line: 2, Column: 13}},
+		{"1;\nlen(" + strings.TrimSuffix(strings.Repeat("1, ", 256), ", ") + ")", token.Position{Line: 2, Column: 4}},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("expected compiler error for %.40q, got none", tt.input)
+			continue
+		}
+
+		compilerErr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("error is not *Error. got=%T (%+v)", err, err)
+			continue
+		}
+
+		if compilerErr.Pos != tt.expected {
+			t.Errorf("wrong position for %.40q. expected=%s, got=%s", tt.input, tt.expected, compilerErr.Pos)
+		}
+	}
+}
+
+func TestBreakOutsideOfLoop(t *testing.T) {
+	compiler := New()
+
+	err := compiler.Compile(&ast.BreakStatement{})
+	if err == nil {
+		t.Fatalf("expected compiler error, got none")
+	}
+
+	if err.Error() != "break outside of loop" {
+		t.Errorf("wrong error message. expected=%q, got=%q", "break outside of loop", err)
+	}
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			"let one = 1; let two = 2;",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			"let one = 1; one;",
+			[]any{1},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"let one = 1; let one = 2; one;",
+			[]any{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"let one = 1; let two = one; two;",
+			[]any{1},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestUndefinedVariable(t *testing.T) {
+	compiler := New()
+
+	err := compiler.Compile(parse("foobar"))
+	if err == nil {
+		t.Fatalf("expected compiler error, got none")
+	}
+
+	if err.Error() != "1:1: identifier not found: foobar" {
+		t.Errorf("wrong error message. expected=%q, got=%q", "1:1: identifier not found: foobar", err)
+	}
+}
+
+func TestBindingNames(t *testing.T) {
+	compiler := New()
+
+	err := compiler.Compile(parse("let a = 1; let f = fn(x, y) { let z = x; if (y) { let w = 2 } }; let b = 2"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// The VM uses the names to report a variable that is read before its let ran
+	bytecode := compiler.ByteCode()
+	if expected := []string{"a", "f", "b"}; !reflect.DeepEqual(bytecode.Globals, expected) {
+		t.Errorf("wrong globals. expected=%q, got=%q", expected, bytecode.Globals)
+	}
+
+	fn, ok := bytecode.Constants[len(bytecode.Constants)-2].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant is not a function. got=%T", bytecode.Constants[len(bytecode.Constants)-2])
+	}
+
+	if expected := []string{"x", "y", "z", "w"}; !reflect.DeepEqual(fn.Locals, expected) {
+		t.Errorf("wrong locals. expected=%q, got=%q", expected, fn.Locals)
+	}
+}
+
+func TestCompilerWithState(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	constants := []object.Object{}
+
+	first := NewWithState(symbolTable, constants)
+	err := first.Compile(parse("let one = 1;"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	second := NewWithState(symbolTable, first.ByteCode().Constants)
+	err = second.Compile(parse("let two = 2; one + two;"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := second.ByteCode()
+
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpSetGlobal, 1),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpGetGlobal, 1),
+		code.Make(code.OpAdd),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Errorf("testInstructions failed: %s", err)
+	}
+
+	err = testConstants([]any{1, 2}, bytecode.Constants)
+	if err != nil {
+		t.Errorf("testConstants failed: %s", err)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			"fn() { return 5 + 10 }",
+			[]any{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"fn() { 5 + 10 }",
+			[]any{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"fn() { 1; 2 }",
+			[]any{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"fn() { }",
+			[]any{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerScopes(t *testing.T) {
+	compiler := New()
+	if compiler.scopeIndex != 0 {
+		t.Errorf("scopeIndex wrong. got=%d, want=%d", compiler.scopeIndex, 0)
+	}
+
+	globalSymbolTable := compiler.symbolTable
+
+	compiler.emit(code.OpMul)
+
+	compiler.enterScope()
+	if compiler.scopeIndex != 1 {
+		t.Errorf("scopeIndex wrong. got=%d, want=%d", compiler.scopeIndex, 1)
+	}
+
+	compiler.emit(code.OpSub)
+
+	if len(compiler.scopes[compiler.scopeIndex].instructions) != 1 {
+		t.Errorf(
+			"instructions length wrong. got=%d",
+			len(compiler.scopes[compiler.scopeIndex].instructions),
+		)
+	}
+
+	last := compiler.scopes[compiler.scopeIndex].lastInstruction
+	if last.Opcode != code.OpSub {
+		t.Errorf("lastInstruction.Opcode wrong. got=%d, want=%d", last.Opcode, code.OpSub)
+	}
+
+	if compiler.symbolTable.Outer != globalSymbolTable {
+		t.Errorf("compiler did not enclose symbolTable")
+	}
+
+	compiler.leaveScope()
+	if compiler.scopeIndex != 0 {
+		t.Errorf("scopeIndex wrong. got=%d, want=%d", compiler.scopeIndex, 0)
+	}
+
+	if compiler.symbolTable != globalSymbolTable {
+		t.Errorf("compiler did not restore global symbol table")
+	}
+
+	if compiler.symbolTable.Outer != nil {
+		t.Errorf("compiler modified global symbol table incorrectly")
+	}
+
+	compiler.emit(code.OpAdd)
+
+	if len(compiler.scopes[compiler.scopeIndex].instructions) != 2 {
+		t.Errorf(
+			"instructions length wrong. got=%d",
+			len(compiler.scopes[compiler.scopeIndex].instructions),
+		)
+	}
+
+	last = compiler.scopes[compiler.scopeIndex].lastInstruction
+	if last.Opcode != code.OpAdd {
+		t.Errorf("lastInstruction.Opcode wrong. got=%d, want=%d", last.Opcode, code.OpAdd)
+	}
+
+	previous := compiler.scopes[compiler.scopeIndex].previousInstruction
+	if previous.Opcode != code.OpMul {
+		t.Errorf("previousInstruction.Opcode wrong. got=%d, want=%d", previous.Opcode, code.OpMul)
+	}
+}
+
+func TestFunctionCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			"fn() { 24 }();",
+			[]any{
+				24,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
+			},
+		},
+		{
+			"let noArg = fn() { 24 }; noArg();",
+			[]any{
+				24,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"let manyArg = fn(a, b, c) { a; b; c }; manyArg(24, 25, 26);",
+			[]any{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+				24,
+				25,
+				26,
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpCall, 3),
 				code.Make(code.OpPop),
 			},
 		},
@@ -116,124 +1151,353 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
-func TestBooleanExpression(t *testing.T) {
+func TestLetStatementScopes(t *testing.T) {
 	tests := []compilerTestCase{
 		{
-			"true; false",
-			[]any{},
+			"let num = 55; fn() { num }",
+			[]any{
+				55,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			[]code.Instructions{
-				code.Make(code.OpTrue),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
-				code.Make(code.OpFalse),
+			},
+		},
+		{
+			"fn() { let a = 55; let b = 77; a + b }",
+			[]any{
+				55,
+				77,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
 		{
-			"false",
-			[]any{},
+			"fn(a) { fn(b) { a + b } }",
+			[]any{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			[]code.Instructions{
-				code.Make(code.OpFalse),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			"1 > 2",
-			[]any{1, 2},
+			"fn(a) { fn(b) { fn(c) { a + b + c } } };",
+			[]any{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			"let countDown = fn(x) { countDown(x - 1); }; countDown(1);",
+			[]any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"fn() { let countDown = fn(x) { countDown(x - 1); }; countDown(1); }",
+			[]any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			`"monkey"`,
+			[]any{"monkey"},
 			[]code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGT),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			"1 < 2",
-			[]any{2, 1},
+			`"mon" + "key"`,
+			[]any{"mon", "key"},
 			[]code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGT),
+				code.Make(code.OpAdd),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			"1 == 2",
-			[]any{1, 2},
+			`"1 + 2 = ${1 + 2}!"`,
+			[]any{"1 + 2 = ", 1, 2, "!"},
 			[]code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpEQ),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConcat, 3),
 				code.Make(code.OpPop),
 			},
 		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []compilerTestCase{
 		{
-			"1 != 2",
-			[]any{1, 2},
+			"[]",
+			[]any{},
+			[]code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"[1, 2, 3]",
+			[]any{1, 2, 3},
 			[]code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpNEQ),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			"true == true",
-			[]any{},
+			"[1 + 2, 3 - 4, 5 * 6]",
+			[]any{1, 2, 3, 4, 5, 6},
 			[]code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpTrue),
-				code.Make(code.OpEQ),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpSub),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpMul),
+				code.Make(code.OpArray, 3),
 				code.Make(code.OpPop),
 			},
 		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []compilerTestCase{
 		{
-			"false == true",
+			"{}",
 			[]any{},
 			[]code.Instructions{
-				code.Make(code.OpFalse),
-				code.Make(code.OpTrue),
-				code.Make(code.OpEQ),
+				code.Make(code.OpHash, 0),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			"true != true",
-			[]any{},
+			"{1: 2, 3: 4, 5: 6}",
+			[]any{1, 2, 3, 4, 5, 6},
 			[]code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpTrue),
-				code.Make(code.OpNEQ),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpHash, 6),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			"!true",
-			[]any{},
+			"{1: 2 + 3, 4: 5 * 6}",
+			[]any{1, 2, 3, 4, 5, 6},
 			[]code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpBang),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpMul),
+				code.Make(code.OpHash, 4),
 				code.Make(code.OpPop),
 			},
 		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []compilerTestCase{
 		{
-			"!true != true",
-			[]any{},
+			"[1, 2, 3][1 + 1]",
+			[]any{1, 2, 3, 1, 1},
 			[]code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpBang),
-				code.Make(code.OpTrue),
-				code.Make(code.OpNEQ),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpAdd),
+				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			"!(1 != 2)",
-			[]any{1, 2},
+			"{1: 2}[2 - 1]",
+			[]any{1, 2, 2, 1},
 			[]code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpNEQ),
-				code.Make(code.OpBang),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpSub),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			"len([]); push([], 1);",
+			[]any{1},
+			[]code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 4),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"fn() { len([]) }",
+			[]any{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			[]code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -322,6 +1586,29 @@ func testConstants(expected []any, actual []object.Object) error {
 			if err != nil {
 				return fmt.Errorf("failed to create consant in position %d: %s", i, err)
 			}
+
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("failed to create consant in position %d: %s", i, err)
+			}
+
+		case string:
+			err := testStringObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("failed to create consant in position %d: %s", i, err)
+			}
+
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d is not a function. got=%T", i, actual[i])
+			}
+
+			err := testInstructions(constant, fn.Instructions)
+			if err != nil {
+				return fmt.Errorf("failed to create consant in position %d: %s", i, err)
+			}
 		}
 	}
 
@@ -340,3 +1627,29 @@ func testIntegerObject(expected int64, actual object.Object) error {
 
 	return nil
 }
+
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not of type *object.Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("mismatched values. expected=%g, got=%g", expected, result.Value)
+	}
+
+	return nil
+}
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not of type *object.String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("mismatched values. expected=%q, got=%q", expected, result.Value)
+	}
+
+	return nil
+}
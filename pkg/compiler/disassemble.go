@@ -0,0 +1,141 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/grantwforsythe/monkeylang/pkg/code"
+	"github.com/grantwforsythe/monkeylang/pkg/object"
+)
+
+// Disassemble writes a human readable listing of the bytecode: the constant pool, the instructions of the main program
+// and then the instructions of every compiled function, in the order in which they are first created by a closure.
+//
+// Each run of instructions compiled from the same line is preceded by that line. The sources map the name of each file
+// in the source map to its contents, the line number is printed on its own if the file is not in sources.
+func (b *ByteCode) Disassemble(w io.Writer, sources map[string]string) {
+	d := &disassembler{w: w, bytecode: b, sources: map[string][]string{}, visited: map[int]bool{}}
+	for file, source := range sources {
+		d.sources[file] = strings.Split(source, "\n")
+	}
+
+	fmt.Fprintln(w, "== constants ==")
+	for i, constant := range b.Constants {
+		fmt.Fprintf(w, "%04d %s %s\n", i, constant.Type(), d.describe(constant))
+	}
+
+	fmt.Fprintln(w, "\n== main ==")
+	d.instructions(b.Instructions, b.SourceMap)
+
+	// Functions that are never turned into a closure can not be reached from main but are still part of the bytecode
+	for i, constant := range b.Constants {
+		if _, ok := constant.(*object.CompiledFunction); ok {
+			d.function(i)
+		}
+	}
+}
+
+// disassembler holds the state needed to disassemble one program.
+type disassembler struct {
+	w        io.Writer
+	bytecode *ByteCode
+	sources  map[string][]string // sources represents the lines of each source file.
+	visited  map[int]bool        // visited represents the constant indexes of the functions that have already been written.
+}
+
+// function writes the instructions of the compiled function at the given constant index, followed by the functions
+// it creates closures for.
+func (d *disassembler) function(index int) {
+	if index >= len(d.bytecode.Constants) {
+		return
+	}
+
+	fn, ok := d.bytecode.Constants[index].(*object.CompiledFunction)
+	if !ok || d.visited[index] {
+		return
+	}
+	d.visited[index] = true
+
+	fmt.Fprintf(d.w, "\n== constant %04d: %s ==\n", index, d.describe(fn))
+	d.instructions(fn.Instructions, fn.SourceMap)
+}
+
+// instructions writes each instruction, annotated with the line it was compiled from and the constant or builtin it
+// refers to, and then disassembles every function that the instructions create a closure for.
+func (d *disassembler) instructions(ins code.Instructions, sourceMap code.SourceMap) {
+	closures := []int{}
+	lastLine := ""
+
+	ins.Disassemble(func(offset int, text string) {
+		if pos, ok := sourceMap[offset]; ok && pos.IsValid() {
+			if line := d.line(pos.File, pos.Line); line != lastLine {
+				fmt.Fprintln(d.w, line)
+				lastLine = line
+			}
+		}
+
+		comment := ""
+		if !strings.HasPrefix(text, "ERROR") {
+			switch code.Opcode(ins[offset]) {
+			case code.OpConstant:
+				comment = d.constant(int(code.ReadUint16(ins[offset+1:])))
+			case code.OpClosure:
+				index := int(code.ReadUint16(ins[offset+1:]))
+				comment = d.constant(index)
+				closures = append(closures, index)
+			case code.OpGetBuiltin:
+				if index := int(code.ReadUint8(ins[offset+1:])); index < len(object.Builtins) {
+					comment = object.Builtins[index].Name
+				}
+			}
+		}
+
+		if comment != "" {
+			fmt.Fprintf(d.w, "%04d %-24s ; %s\n", offset, text, comment)
+		} else {
+			fmt.Fprintf(d.w, "%04d %s\n", offset, text)
+		}
+	})
+
+	for _, index := range closures {
+		d.function(index)
+	}
+}
+
+// line formats a line of the source code, or only its number if the source is not known.
+func (d *disassembler) line(file string, number int) string {
+	location := strconv.Itoa(number)
+	if file != "" {
+		location = file + ":" + location
+	}
+
+	lines := d.sources[file]
+	if number > len(lines) {
+		return fmt.Sprintf("     ; %s", location)
+	}
+
+	return fmt.Sprintf("     ; %s | %s", location, strings.TrimSpace(lines[number-1]))
+}
+
+// constant describes the constant at the given index, or reports that it does not exist.
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.bytecode.Constants) {
+		return fmt.Sprintf("constant %d does not exist", index)
+	}
+
+	return d.describe(d.bytecode.Constants[index])
+}
+
+// describe formats a constant so that its type is clear, e.g. strings are quoted.
+func (d *disassembler) describe(constant object.Object) string {
+	switch constant := constant.(type) {
+	case *object.String:
+		return strconv.Quote(constant.Value)
+	case *object.CompiledFunction:
+		return fmt.Sprintf("fn parameters=%d locals=%d", constant.NumParameters, constant.NumLocals)
+	default:
+		return constant.Inspect()
+	}
+}
@@ -0,0 +1,89 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grantwforsythe/monkeylang/pkg/lexer"
+	"github.com/grantwforsythe/monkeylang/pkg/parser"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := "let adder = fn(x) {\n  fn(y) { x + y }\n};\nlen(adder(1)(2) + \"a\");"
+
+	comp := New()
+	if err := comp.Compile(parser.New(lexer.NewWithFile("adder.monkey", input)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `== constants ==
+0000 COMPILED_FUNCTION fn parameters=1 locals=1
+0001 COMPILED_FUNCTION fn parameters=1 locals=1
+0002 INTEGER 1
+0003 INTEGER 2
+0004 STRING "a"
+
+== main ==
+     ; adder.monkey:1 | let adder = fn(x) {
+0000 OpClosure 1 0            ; fn parameters=1 locals=1
+0004 OpSetGlobal 0
+     ; adder.monkey:4 | len(adder(1)(2) + "a");
+0007 OpGetBuiltin 0           ; len
+0009 OpGetGlobal 0
+0012 OpConstant 2             ; 1
+0015 OpCall 1
+0017 OpConstant 3             ; 2
+0020 OpCall 1
+0022 OpConstant 4             ; "a"
+0025 OpAdd
+0026 OpCall 1
+0028 OpPop
+
+== constant 0001: fn parameters=1 locals=1 ==
+     ; adder.monkey:2 | fn(y) { x + y }
+0000 OpGetLocal 0
+0002 OpClosure 0 1            ; fn parameters=1 locals=1
+0006 OpReturnValue
+
+== constant 0000: fn parameters=1 locals=1 ==
+     ; adder.monkey:2 | fn(y) { x + y }
+0000 OpGetFree 0
+0002 OpGetLocal 0
+0004 OpAdd
+0005 OpReturnValue
+`
+
+	var out strings.Builder
+	comp.ByteCode().Disassemble(&out, map[string]string{"adder.monkey": input})
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestDisassembleWithoutSource(t *testing.T) {
+	comp := New()
+	if err := comp.Compile(parser.New(lexer.NewWithFile("moved.monkey", "1;\n\n2;")).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `== constants ==
+0000 INTEGER 1
+0001 INTEGER 2
+
+== main ==
+     ; moved.monkey:1
+0000 OpConstant 0             ; 1
+0003 OpPop
+     ; moved.monkey:3
+0004 OpConstant 1             ; 2
+0007 OpPop
+`
+
+	var out strings.Builder
+	comp.ByteCode().Disassemble(&out, nil)
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
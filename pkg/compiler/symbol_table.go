@@ -0,0 +1,188 @@
+package compiler
+
+import "github.com/grantwforsythe/monkeylang/pkg/object"
+
+// SymbolScope represents the scope in which a symbol was defined.
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"   // GlobalScope represents symbols defined at the top level of a program.
+	LocalScope    SymbolScope = "LOCAL"    // LocalScope represents symbols defined inside of a function.
+	BuiltinScope  SymbolScope = "BUILTIN"  // BuiltinScope represents functions that are built into the language.
+	FreeScope     SymbolScope = "FREE"     // FreeScope represents symbols captured from an enclosing scope by a closure.
+	FunctionScope SymbolScope = "FUNCTION" // FunctionScope represents the name of the function currently being compiled.
+)
+
+// Symbol represents an identifier and all of the information the compiler needs to know about it.
+type Symbol struct {
+	Name  string      // Name represents the identifier used in the source code.
+	Scope SymbolScope // Scope represents the scope in which the symbol was defined.
+	Index int         // Index represents the position of the symbol within its scope.
+	Boxed bool        // Boxed represents whether the value is stored in a cell so that closures can assign to it.
+}
+
+// SymbolTable associates identifiers with the information needed to load and store them.
+type SymbolTable struct {
+	Outer *SymbolTable // Outer represents the enclosing symbol table, nil for the global symbol table.
+
+	FreeSymbols []Symbol // FreeSymbols represents the original symbols of all free variables captured in this scope.
+
+	store          map[string]Symbol
+	numDefinitions int
+	boxed          map[string]bool // boxed represents the identifiers which are stored in cells once defined in this scope.
+	reserved       map[string]int  // reserved represents the indexes set aside for boxed locals that are not defined yet.
+}
+
+// NewSymbolTable creates a new global symbol table.
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		store:       make(map[string]Symbol),
+		FreeSymbols: []Symbol{},
+	}
+}
+
+// NewSymbolTableWithBuiltins creates a new global symbol table with every builtin function defined.
+func NewSymbolTableWithBuiltins() *SymbolTable {
+	s := NewSymbolTable()
+	for i, builtin := range object.Builtins {
+		s.DefineBuiltin(i, builtin.Name)
+	}
+	return s
+}
+
+// NewEnclosedSymbolTable creates a new symbol table which is enclosed by outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Copy creates a copy of the symbol table which is not affected by definitions made in the original afterwards, e.g. to
+// discard the definitions of a program which failed to compile. The enclosing symbol tables are shared.
+func (s *SymbolTable) Copy() *SymbolTable {
+	c := *s
+	c.FreeSymbols = append([]Symbol{}, s.FreeSymbols...)
+	c.store = make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		c.store[name] = symbol
+	}
+
+	return &c
+}
+
+// Define associates an identifier with a new symbol in the current scope.
+// Redefining an identifier in the same scope reuses its symbol so that code compiled against the previous definition,
+// e.g. the condition of a loop, observes the new value.
+// The number of symbols is not limited here, the compiler reports a scope with more symbols than its instructions can
+// refer to.
+// Returns the newly defined symbol.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
+	if index, ok := s.reserved[name]; ok {
+		symbol := Symbol{Name: name, Index: index, Scope: LocalScope, Boxed: true}
+		s.store[name] = symbol
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+		// Globals are shared by every function so they never need to be boxed.
+		symbol.Boxed = s.boxed[name]
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// Box marks identifiers which are stored in cells once they are defined in the current scope.
+// A local has to be boxed when it is captured by a closure and assigned to after it was defined, so that the enclosing
+// function and every closure observe the assignment.
+func (s *SymbolTable) Box(names map[string]bool) {
+	s.boxed = names
+}
+
+// Reserve sets aside the index of a boxed local before it is defined, so that its cell can be created when the function
+// is entered rather than each time its let statement runs. Defining the name later uses the reserved index.
+// Returns the reserved index.
+func (s *SymbolTable) Reserve(name string) int {
+	if s.reserved == nil {
+		s.reserved = make(map[string]int)
+	}
+
+	index := s.numDefinitions
+	s.reserved[name] = index
+	s.numDefinitions++
+
+	return index
+}
+
+// names gets the names of the globals or locals defined in the current scope by their index, including the boxed locals
+// which have only been reserved so far.
+func (s *SymbolTable) names() []string {
+	names := make([]string, s.numDefinitions)
+	for name, index := range s.reserved {
+		names[index] = name
+	}
+
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			names[symbol.Index] = symbol.Name
+		}
+	}
+
+	return names
+}
+
+// DefineBuiltin associates an identifier with a builtin function at the given index.
+// Returns the newly defined symbol.
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName associates the name of the function being compiled with the current scope.
+// Returns the newly defined symbol.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// defineFree records original as a free variable of the current scope.
+// Returns the symbol which refers to the free variable.
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Boxed: original.Boxed}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve gets the symbol associated with an identifier, searching all enclosing scopes.
+// Local symbols of an enclosing scope are resolved as free variables of the current scope.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok {
+		return symbol, ok
+	}
+
+	// Globals and builtins are reachable from every scope so they do not need to be captured.
+	if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+
+	return s.defineFree(symbol), true
+}
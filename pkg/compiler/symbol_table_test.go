@@ -0,0 +1,228 @@
+package compiler
+
+import "testing"
+
+func TestDefine(t *testing.T) {
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 1},
+		"c": {Name: "c", Scope: LocalScope, Index: 0},
+		"d": {Name: "d", Scope: LocalScope, Index: 1},
+		"e": {Name: "e", Scope: LocalScope, Index: 0},
+		"f": {Name: "f", Scope: LocalScope, Index: 1},
+	}
+
+	global := NewSymbolTable()
+	firstLocal := NewEnclosedSymbolTable(global)
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+
+	tests := []struct {
+		table *SymbolTable
+		name  string
+	}{
+		{global, "a"},
+		{global, "b"},
+		{firstLocal, "c"},
+		{firstLocal, "d"},
+		{secondLocal, "e"},
+		{secondLocal, "f"},
+	}
+
+	for _, test := range tests {
+		symbol := test.table.Define(test.name)
+		if symbol != expected[test.name] {
+			t.Errorf("expected %s=%+v, got=%+v", test.name, expected[test.name], symbol)
+		}
+	}
+}
+
+func TestResolveGlobal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+	}
+
+	for _, symbol := range expected {
+		result, ok := global.Resolve(symbol.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", symbol.Name)
+			continue
+		}
+
+		if result != symbol {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", symbol.Name, symbol, result)
+		}
+	}
+}
+
+func TestResolveNestedLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Define("d")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+		{Name: "c", Scope: LocalScope, Index: 0},
+		{Name: "d", Scope: LocalScope, Index: 1},
+	}
+
+	for _, symbol := range expected {
+		result, ok := local.Resolve(symbol.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", symbol.Name)
+			continue
+		}
+
+		if result != symbol {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", symbol.Name, symbol, result)
+		}
+	}
+}
+
+func TestDefineResolveBuiltins(t *testing.T) {
+	global := NewSymbolTable()
+	firstLocal := NewEnclosedSymbolTable(global)
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+
+	expected := []Symbol{
+		{Name: "a", Scope: BuiltinScope, Index: 0},
+		{Name: "c", Scope: BuiltinScope, Index: 1},
+		{Name: "e", Scope: BuiltinScope, Index: 2},
+		{Name: "f", Scope: BuiltinScope, Index: 3},
+	}
+
+	for i, symbol := range expected {
+		global.DefineBuiltin(i, symbol.Name)
+	}
+
+	for _, table := range []*SymbolTable{global, firstLocal, secondLocal} {
+		for _, symbol := range expected {
+			result, ok := table.Resolve(symbol.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", symbol.Name)
+				continue
+			}
+
+			if result != symbol {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", symbol.Name, symbol, result)
+			}
+		}
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+	firstLocal.Define("d")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+	secondLocal.Define("f")
+
+	tests := []struct {
+		table               *SymbolTable
+		expectedSymbols     []Symbol
+		expectedFreeSymbols []Symbol
+	}{
+		{
+			firstLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "b", Scope: GlobalScope, Index: 1},
+				{Name: "c", Scope: LocalScope, Index: 0},
+				{Name: "d", Scope: LocalScope, Index: 1},
+			},
+			[]Symbol{},
+		},
+		{
+			secondLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "b", Scope: GlobalScope, Index: 1},
+				{Name: "c", Scope: FreeScope, Index: 0},
+				{Name: "d", Scope: FreeScope, Index: 1},
+				{Name: "e", Scope: LocalScope, Index: 0},
+				{Name: "f", Scope: LocalScope, Index: 1},
+			},
+			[]Symbol{
+				{Name: "c", Scope: LocalScope, Index: 0},
+				{Name: "d", Scope: LocalScope, Index: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		for _, symbol := range test.expectedSymbols {
+			result, ok := test.table.Resolve(symbol.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", symbol.Name)
+				continue
+			}
+
+			if result != symbol {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", symbol.Name, symbol, result)
+			}
+		}
+
+		if len(test.table.FreeSymbols) != len(test.expectedFreeSymbols) {
+			t.Errorf(
+				"wrong number of free symbols. expected=%d, got=%d",
+				len(test.expectedFreeSymbols),
+				len(test.table.FreeSymbols),
+			)
+			continue
+		}
+
+		for i, symbol := range test.expectedFreeSymbols {
+			if test.table.FreeSymbols[i] != symbol {
+				t.Errorf("wrong free symbol. expected=%+v, got=%+v", symbol, test.table.FreeSymbols[i])
+			}
+		}
+	}
+}
+
+func TestResolveUnresolvable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+
+	if _, ok := local.Resolve("c"); ok {
+		t.Errorf("name c resolved, but was expected not to")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	c := global.Copy()
+	global.Define("b")
+
+	if _, ok := c.Resolve("b"); ok {
+		t.Errorf("name b resolved in the copy, but was defined after it was made")
+	}
+
+	expected := Symbol{Name: "c", Scope: GlobalScope, Index: 1}
+	if symbol := c.Define("c"); symbol != expected {
+		t.Errorf("expected c=%+v, got=%+v", expected, symbol)
+	}
+
+	if _, ok := global.Resolve("c"); ok {
+		t.Errorf("name c resolved in the original, but was defined in the copy")
+	}
+}
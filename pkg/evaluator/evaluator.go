@@ -8,6 +8,8 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/grantwforsythe/monkeylang/pkg/ast"
 	"github.com/grantwforsythe/monkeylang/pkg/object"
@@ -15,89 +17,157 @@ import (
 
 // NOTE: env can be refactored into the package scope so it does not need to be passed around
 
+// MaxCallDepth represents the maximum depth of nested function calls.
+const MaxCallDepth = 1024
+
 var (
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
 	NULL  = &object.Null{}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 // Eval recursively walks an AST evaluating each node into their respective objects.
-func Eval(node ast.Node, env *object.Environment) object.Object {
+// A panic caused by a bug in the evaluator is recovered and returned as an error so it never crashes the host.
+func Eval(node ast.Node, env *object.Environment) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+
+	return evalNode(node, env)
+}
+
+// evalNode evaluates a node without recovering from panics, it is used for recursion so the recovery is only set up
+// once per call to Eval.
+func evalNode(node ast.Node, env *object.Environment) object.Object {
+	if !env.Step() {
+		return newError("step budget exhausted")
+	}
+
+	result := eval(node, env)
+
+	// Attribute an error to the innermost node that produced it
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	case *ast.Program:
 		return evalProgram(node, env)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return evalNode(node.Expression, env)
 
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := evalNode(node.Right, env)
+		if isInterrupt(right) {
 			return right
 		}
 
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
+		left := evalNode(node.Left, env)
+		if isInterrupt(left) {
 			return left
 		}
 
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := evalNode(node.Right, env)
+		if isInterrupt(right) {
 			return right
 		}
 
 		return evalInfixExpression(node.Operator, left, right)
 
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.WhileStatement:
+		return evalLoop(nil, node.Condition, nil, node.Body, env)
+
+	case *ast.ForStatement:
+		return evalLoop(node.Init, node.Condition, node.Post, node.Body, env)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
 	case *ast.FunctionLiteral:
-		return &object.Function{Body: node.Body, Env: env, Parameters: node.Parameters}
+		return &object.Function{Name: node.Name, Body: node.Body, Env: env, Parameters: node.Parameters}
 
 	case *ast.CallExpression:
 		// TODO: Refactor this
 		// Skip evaluation of argument when calling `quote`
 		// Quote only accepts one argument
 		if node.Function.TokenLiteral() == "quote" {
+			if len(node.Arguments) != 1 {
+				return newError("wrong number of arguments to `quote`. got=%d, want=1", len(node.Arguments))
+			}
 			return quote(node.Arguments[0], env)
 		}
 
-		fn := Eval(node.Function, env)
-		if isError(fn) {
+		fn := evalNode(node.Function, env)
+		if isInterrupt(fn) {
 			return fn
 		}
 
 		// Evaluate the arguments
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isInterrupt(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(fn, args)
+		result := applyFunction(fn, args)
+
+		// Record the call as the error propagates so the stack trace reads from the innermost call outwards
+		if err, ok := result.(*object.Error); ok {
+			if name, ok := functionName(node, fn); ok {
+				err.Stack = append(err.Stack, object.StackFrame{Function: name, Pos: node.Pos()})
+			}
+		}
+
+		return result
 
 	case *ast.ReturnStatement:
-		value := Eval(node.ReturnValue, env)
-		if isError(value) {
+		value := evalNode(node.ReturnValue, env)
+		if isInterrupt(value) {
 			return value
 		}
 
 		return &object.ReturnValue{Value: value}
 
 	case *ast.LetStatement:
-		value := Eval(node.Value, env)
-		if isError(value) {
+		value := evalNode(node.Value, env)
+		if isInterrupt(value) {
 			return value
 		}
 
 		env.Set(node.Name.String(), value)
 
+		// Statements produce null so that a block ending in one, e.g. the body of a function, still has a value
+		return NULL
+
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 
@@ -105,28 +175,49 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalBooleanExpression(node.Value)
 
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return &object.Integer{Value: node.Value, Big: node.Big}
+
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
+	case *ast.InterpolatedString:
+		parts := evalExpressions(node.Parts, env)
+
+		if len(parts) == 1 && isInterrupt(parts[0]) {
+			return parts[0]
+		}
+
+		var out strings.Builder
+		for _, part := range parts {
+			s, ok := object.InspectLimited(part, object.MaxStringLength-out.Len())
+			if !ok {
+				return newError("string too long: more than %d bytes", object.MaxStringLength)
+			}
+			out.WriteString(s)
+		}
+
+		return &object.String{Value: out.String()}
+
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
 
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isInterrupt(elements[0]) {
 			return elements[0]
 		}
 
 		return &object.Array{Elements: elements}
 
 	case *ast.IndexEpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := evalNode(node.Left, env)
+		if isInterrupt(left) {
 			return left
 		}
 
-		index := Eval(node.Index, env)
-		if isError(index) {
+		index := evalNode(node.Index, env)
+		if isInterrupt(index) {
 			return index
 		}
 
@@ -137,13 +228,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		hash.Pairs = make(map[object.HashKey]object.HashPair)
 
 		for key, value := range node.Pairs {
-			keyObj := Eval(key, env)
-			if isError(keyObj) {
+			keyObj := evalNode(key, env)
+			if isInterrupt(keyObj) {
 				return keyObj
 			}
 
-			valueObj := Eval(value, env)
-			if isError(valueObj) {
+			valueObj := evalNode(value, env)
+			if isInterrupt(valueObj) {
 				return valueObj
 			}
 
@@ -168,7 +259,7 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range program.Statements {
-		result = Eval(stmt, env)
+		result = evalNode(stmt, env)
 
 		switch obj := result.(type) {
 		case *object.ReturnValue:
@@ -191,37 +282,34 @@ func evalBooleanExpression(value bool) object.Object {
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
-		switch right {
-		case TRUE:
-			return FALSE
-		case FALSE:
-			return TRUE
-		case NULL:
-			return TRUE
-		default:
-			return FALSE
-		}
+		return evalBooleanExpression(!isTruthy(right))
 	case "-":
-		if right, ok := right.(*object.Integer); ok {
-			return &object.Integer{Value: -1 * right.Value}
+		switch right := right.(type) {
+		case *object.Integer:
+			return right.Neg()
+		case *object.Float:
+			return &object.Float{Value: -right.Value}
+		default:
+			return newError("unknown operator: -%s", right.Type())
 		}
-		return newError("unknown operator: -%s", right.Type())
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
 }
 
 func evalBlockStatement(node *ast.BlockStatement, env *object.Environment) object.Object {
-	var result object.Object
+	// An empty block evaluates to null
+	var result object.Object = NULL
 
 	for _, stmt := range node.Statements {
-		result = Eval(stmt, env)
+		result = evalNode(stmt, env)
 
 		if result == nil {
 			continue
 		}
 
-		if result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ {
+		switch result.Type() {
+		case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 			return result
 		}
 	}
@@ -234,6 +322,9 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	// An integer mixed with a float is converted to a float
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
@@ -249,22 +340,88 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	lValue := left.(*object.Integer).Value
-	rValue := right.(*object.Integer).Value
+	l := left.(*object.Integer)
+	r := right.(*object.Integer)
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: lValue + rValue}
+		return l.Add(r)
 	case "-":
-		return &object.Integer{Value: lValue - rValue}
+		return l.Sub(r)
 	case "*":
-		return &object.Integer{Value: lValue * rValue}
+		result, ok := l.Mul(r)
+		if !ok {
+			return newError("integer too large: more than %d bits", object.MaxIntegerBits)
+		}
+		return result
 	case "/":
-		return &object.Integer{Value: lValue / rValue}
+		if r.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return l.Quo(r)
+	case "%":
+		if r.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return l.Rem(r)
+	case "**":
+		if r.Sign() < 0 {
+			return newError("negative exponent: %s", r.Inspect())
+		}
+
+		result, ok := l.Pow(r)
+		if !ok {
+			return newError("exponent too large: %s", r.Inspect())
+		}
+		return result
+	case "<":
+		return evalBooleanExpression(l.Cmp(r) < 0)
+	case ">":
+		return evalBooleanExpression(l.Cmp(r) > 0)
+	case "<=":
+		return evalBooleanExpression(l.Cmp(r) <= 0)
+	case ">=":
+		return evalBooleanExpression(l.Cmp(r) >= 0)
+	case "==":
+		return evalBooleanExpression(l.Cmp(r) == 0)
+	case "!=":
+		return evalBooleanExpression(l.Cmp(r) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	lValue := toFloat(left)
+	rValue := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: lValue + rValue}
+	case "-":
+		return &object.Float{Value: lValue - rValue}
+	case "*":
+		return &object.Float{Value: lValue * rValue}
+	case "/":
+		if rValue == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: lValue / rValue}
+	case "%":
+		if rValue == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Mod(lValue, rValue)}
+	case "**":
+		return &object.Float{Value: math.Pow(lValue, rValue)}
 	case "<":
 		return evalBooleanExpression(lValue < rValue)
 	case ">":
 		return evalBooleanExpression(lValue > rValue)
+	case "<=":
+		return evalBooleanExpression(lValue <= rValue)
+	case ">=":
+		return evalBooleanExpression(lValue >= rValue)
 	case "==":
 		return evalBooleanExpression(lValue == rValue)
 	case "!=":
@@ -280,6 +437,9 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 
 	switch operator {
 	case "+":
+		if len(lValue)+len(rValue) > object.MaxStringLength {
+			return newError("string too long: more than %d bytes", object.MaxStringLength)
+		}
 		return &object.String{Value: lValue + rValue}
 	case "==":
 		return evalBooleanExpression(lValue == rValue)
@@ -290,35 +450,108 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	}
 }
 
+// evalLogicalExpression evaluates && and || which only evaluate their right operand if the left one does not already
+// decide the result. The result is always a boolean.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := evalNode(node.Left, env)
+	if isInterrupt(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := evalNode(node.Right, env)
+	if isInterrupt(right) {
+		return right
+	}
+
+	return evalBooleanExpression(isTruthy(right))
+}
+
 func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(node.Condition, env)
-	if isError(condition) {
+	condition := evalNode(node.Condition, env)
+	if isInterrupt(condition) {
 		return condition
 	}
 
 	if isTruthy(condition) {
-		return Eval(node.Consequence, env)
+		return evalNode(node.Consequence, env)
 	} else if node.Alternative != nil {
-		return Eval(node.Alternative, env)
+		return evalNode(node.Alternative, env)
 	} else {
 		return NULL
 	}
 }
 
+// evalLoop evaluates the body of a loop for as long as its condition is truthy, or until it breaks.
+// The init and post statements as well as the condition are optional. Loops are statements so they do not produce a value.
+func evalLoop(init ast.Statement, condition ast.Expression, post ast.Statement, body *ast.BlockStatement, env *object.Environment) object.Object {
+	if init != nil {
+		if result := evalNode(init, env); isInterrupt(result) {
+			return result
+		}
+	}
+
+	for {
+		if condition != nil {
+			result := evalNode(condition, env)
+			if isInterrupt(result) {
+				return result
+			}
+
+			if !isTruthy(result) {
+				return NULL
+			}
+		}
+
+		result := evalNode(body, env)
+		if result != nil {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+				return result
+			case object.BREAK_OBJ:
+				return NULL
+			}
+		}
+
+		if post != nil {
+			if result := evalNode(post, env); isInterrupt(result) {
+				return result
+			}
+		}
+	}
+}
+
+// isTruthy determines if an object is truthy, see object.IsTruthy for which objects are falsy.
 func isTruthy(obj object.Object) bool {
+	// Booleans are by far the most common condition so they are compared by pointer before anything else
 	switch obj {
-	case NULL:
-		return false
 	case TRUE:
 		return true
 	case FALSE:
 		return false
 	default:
-		// There are currently only 3 object types: Integer, Boolean, and Null
-		// If obj is not of type Boolean or Null, then we know it has to be of type Integer
-		// TODO: Handle the case for more object types
-		return obj.(*object.Integer).Value > 0
+		return object.IsTruthy(obj)
+	}
+}
+
+// isNumber checks if an object is either an integer or a float.
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat converts an integer or float to a float.
+func toFloat(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return integer.Float()
 	}
+	return obj.(*object.Float).Value
 }
 
 func newError(format string, a ...any) *object.Error {
@@ -332,12 +565,26 @@ func isError(obj object.Object) bool {
 	return false
 }
 
+// isInterrupt checks if an object interrupts the evaluation of the enclosing expression, so it has to be passed up to
+// the statement that handles it instead of being used as a value: an error, a return value, a break or a continue.
+func isInterrupt(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+
+	switch obj.Type() {
+	case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	}
+	return false
+}
+
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if obj, ok := env.Get(node.Value); ok {
 		return obj
 	}
 
-	if builtin, ok := builtin[node.Value]; ok {
+	if builtin := object.GetBuiltinByName(node.Value); builtin != nil {
 		return builtin
 	}
 
@@ -348,8 +595,8 @@ func evalExpressions(expressions []ast.Expression, env *object.Environment) []ob
 	var result []object.Object
 
 	for _, expression := range expressions {
-		eval := Eval(expression, env)
-		if isError(eval) {
+		eval := evalNode(expression, env)
+		if isInterrupt(eval) {
 			return []object.Object{eval}
 		}
 
@@ -359,9 +606,33 @@ func evalExpressions(expressions []ast.Expression, env *object.Environment) []ob
 	return result
 }
 
+// functionName gets the name to show for a function in a stack trace.
+// A function is named after the binding it was defined with, falling back to the identifier it was called by.
+// Returns false if the object is not a function.
+func functionName(node *ast.CallExpression, fn object.Object) (string, bool) {
+	switch fn := fn.(type) {
+	case *object.Function:
+		if fn.Name != "" {
+			return fn.Name, true
+		}
+	case *object.Builtin:
+	default:
+		return "", false
+	}
+
+	if ident, ok := node.Function.(*ast.Identifier); ok {
+		return ident.Value, true
+	}
+
+	return "<anonymous>", true
+}
+
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
 
 		// Assign the arguments to their corresponding parameter
 		enclosedEnv := object.NewEnclosedEnvironment(fn.Env)
@@ -369,7 +640,13 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 			enclosedEnv.Set(param.Value, args[paramIdx])
 		}
 
-		eval := Eval(fn.Body, enclosedEnv)
+		// Every call is evaluated on the stack of the host so runaway recursion has to be stopped before it overflows
+		defer enclosedEnv.LeaveCall()
+		if enclosedEnv.EnterCall() > MaxCallDepth {
+			return newError("stack overflow: more than %d nested function calls", MaxCallDepth)
+		}
+
+		eval := evalNode(fn.Body, enclosedEnv)
 
 		if result, ok := eval.(*object.ReturnValue); ok {
 			return result.Value
@@ -379,24 +656,131 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 		return eval
 	// TODO: Figure out why this works here
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Fn(args...); result != nil {
+			return result
+		}
+
+		return NULL
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// evalAssignExpression updates an existing binding, or an element of an array or hash, with the value of an assignment.
+// A compound assignment, e.g. x += 1, combines the current value with the new one using the arithmetic operator. The
+// current value is read before the new one is evaluated, like the operands of an infix expression.
+// Returns the value that was assigned.
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	operator := strings.TrimSuffix(node.Operator, "=")
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		// The errors point at the name being assigned rather than at the operator, the same as the compiler's
+		current, ok := env.Get(target.Value)
+		if !ok {
+			err := newError("identifier not found: %s", target.Value)
+			if object.GetBuiltinByName(target.Value) != nil {
+				err = newError("cannot assign to %s", target.Value)
+			}
+			err.Pos = target.Pos()
+			return err
+		}
+
+		value := evalNode(node.Value, env)
+		if isInterrupt(value) {
+			return value
+		}
+
+		if operator != "" {
+			value = evalInfixExpression(operator, current, value)
+			if isError(value) {
+				return value
+			}
+		}
+
+		env.Assign(target.Value, value)
+		return value
+
+	case *ast.IndexEpression:
+		left := evalNode(target.Left, env)
+		if isInterrupt(left) {
+			return left
+		}
+
+		index := evalNode(target.Index, env)
+		if isInterrupt(index) {
+			return index
+		}
+
+		var current object.Object
+		if operator != "" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+
+		value := evalNode(node.Value, env)
+		if isInterrupt(value) {
+			return value
+		}
+
+		if operator != "" {
+			value = evalInfixExpression(operator, current, value)
+			if isError(value) {
+				return value
+			}
+		}
+
+		return evalIndexAssignment(left, index, value)
+
+	default:
+		err := newError("cannot assign to %s", node.Target.String())
+		err.Pos = node.Target.Pos()
+		return err
+	}
+}
+
+// evalIndexAssignment stores a value in an array or hash in place.
+func evalIndexAssignment(left, index, value object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("index must be an integer: %s", index.Type())
+		}
+
+		if idx.IsBig() || idx.Value < 0 || idx.Value > int64(len(left.Elements)-1) {
+			return newError("index out of range: %s", idx.Inspect())
+		}
+
+		left.Elements[idx.Value] = value
+		return value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unhashable key: %s", index.Type())
+		}
+
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+		return value
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		array := left.(*object.Array)
-		idx := index.(*object.Integer).Value
+		idx := index.(*object.Integer)
 
 		// Indexing out of bounds
-		if idx < 0 || idx > int64(len(array.Elements)-1) {
+		if idx.IsBig() || idx.Value < 0 || idx.Value > int64(len(array.Elements)-1) {
 			return NULL
 		}
 
-		return array.Elements[idx]
+		return array.Elements[idx.Value]
 	case left.Type() == object.HASH_OBJ:
 		left := left.(*object.Hash)
 
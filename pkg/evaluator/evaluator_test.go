@@ -1,8 +1,11 @@
 package evaluator
 
 import (
+	"io"
+	"strings"
 	"testing"
 
+	"github.com/grantwforsythe/monkeylang/pkg/ast"
 	"github.com/grantwforsythe/monkeylang/pkg/lexer"
 	"github.com/grantwforsythe/monkeylang/pkg/object"
 	"github.com/grantwforsythe/monkeylang/pkg/parser"
@@ -28,6 +31,15 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"5 ** 0", 1},
+		{"2 * 3 % 4", 2},
+		{"0xFF + 0o17 + 0b1010", 280},
+		{"1_000_000", 1000000},
 	}
 
 	for _, tt := range tests {
@@ -36,6 +48,104 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalBigIntegerExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		// The quotient of the smallest 64-bit integer and -1 does not fit in 64 bits so it is promoted
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"2 ** 100", "1267650600228229401496703205376"},
+		{"99999999999999999999", "99999999999999999999"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		result, ok := evaluated.(*object.Integer)
+		if !ok {
+			t.Errorf("object is not of type *object.Integer. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if !result.IsBig() {
+			t.Errorf("result was not promoted to a big integer. got=%d", result.Value)
+		}
+
+		if result.Inspect() != tt.expected {
+			t.Errorf("result is not equal to %s. got=%s", tt.expected, result.Inspect())
+		}
+	}
+
+	demoted := []struct {
+		input    string
+		expected any
+	}{
+		{"99999999999999999999 % 7", 1},
+		{"(2 ** 64) / (2 ** 32)", 4294967296},
+		{"(2 ** 64) - (2 ** 64) + 1", 1},
+		{"2 ** 64 > 9223372036854775807", true},
+		{"2 ** 64 == 18446744073709551616", true},
+		{"-(2 ** 64) < 0", true},
+		{"{2 ** 64: 1}[18446744073709551616]", 1},
+		{"[1, 2, 3][2 ** 64]", nil},
+	}
+
+	for _, tt := range demoted {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"3.14", 3.14},
+		{"1e9", 1e9},
+		{"2.5e-3", 0.0025},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"5 / 2.0", 2.5},
+		{"2.0 * 3", 6.0},
+		{"-1.5", -1.5},
+		{"5.5 % 2", 1.5},
+		{"2 ** 0.5 ** 2", 1.189207115002721},
+		{"2.0 ** -1", 0.5},
+		{"1.5 > 1", true},
+		{"1 < 1.5", true},
+		{"1.0 == 1", true},
+		{"1.5 != 1.5", false},
+		{"2 >= 2.0", true},
+		{"2 <= 1.9", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -64,6 +174,25 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{`"HELLO" == "WORLD"`, false},
 		{`"HELLO" != "WORLD"`, true},
 		{`"HELLO" != "HELLO"`, false},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"3 >= 2", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || false", false},
+		{"false || true", true},
+		{"true || false", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"false && (1 + true)", false},
+		{"true || (1 + true)", true},
+		{"let x = 0; false && (x = 1); x == 0", true},
+		{"let x = 0; true || (x = 1); x == 0", true},
+		{"let x = 0; true && (x = 1); x == 1", true},
 	}
 
 	for _, tt := range tests {
@@ -101,7 +230,7 @@ func TestEvalIfElseExpression(t *testing.T) {
 		{"if (false) { 10 }", nil},
 		{"if (1) { 10 }", 10},
 		{"if (0) { 10 }", nil},
-		{"if (-1) { 10 }", nil},
+		{"if (-1) { 10 }", 10},
 		{"if (1 < 2) { 10 }", 10},
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
@@ -120,6 +249,104 @@ func TestEvalIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestEvalLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i", 10},
+		{"let i = 0; while (true) { if (i > 4) { break; }; let i = i + 1; }; i", 5},
+		{"let sum = 0; for (let i = 0; i < 10; let i = i + 1) { if (i > 7) { continue; }; let sum = sum + i; }; sum", 28},
+		{"let n = 0; for (;;) { let n = n + 1; if (n == 3) { break; } }; n", 3},
+		{"let sum = 0; for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; j < 3; let j = j + 1) { if (j > i) { break; }; let sum = sum + 1; } }; sum", 6},
+		{"let f = fn(n) { let i = 0; while (true) { if (i == n) { return i * 2; }; let i = i + 1; } }; f(4)", 8},
+		{"while (false) { 1 }", nil},
+		{"let i = 0; while (i < 100000) { let i = i + 1; }; i", 100000},
+		// Loops, let statements and empty blocks evaluate to null so that they can be used as values
+		{"let f = fn() { while (false) { } }; f()", nil},
+		{"let f = fn() { for (;;) { break; } }; f()", nil},
+		{"if (true) { let a = 1; }", nil},
+		{"fn() { }()", nil},
+		// Break, continue and return stop the expression they are nested in, discarding the operands evaluated so far
+		{"let arr = []; for (let j = 1; j < 6; j += 1) { arr = push(arr, if (j == 3) { break; } else { j }) }; len(arr)", 2},
+		{"let s = 0; for (let j = 0; j < 5; j += 1) { s = s + (1 + if (j == 2) { continue; } else { j }) }; s", 12},
+		{"let i = 0; while (i < 5) { i += 1; let x = if (i == 2) { break; } else { 0 }; }; i", 2},
+		{"let k = 0; while (k < 20000) { k += 1; let y = [1, if (true) { continue; }]; }; k", 20000},
+		{"let i = 0; while (i < 5) { i += 1; let x = true && [1, if (i == 3) { break; }]; }; i", 3},
+		{"let n = 0; while (true) { n += 1; while (if (n == 3) { break; } else { false }) { } }; n", 3},
+		{"let f = fn() { [1, 2, if (true) { return 3; }] }; f()", 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if value, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(value))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestEvalAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 1; let y = 1; x = y = 3; x + y", 6},
+		{"let x = 10; x += 5; x", 15},
+		{"let x = 10; x -= 5; x", 5},
+		{"let x = 10; x *= 5; x", 50},
+		{"let x = 10; x /= 5; x", 2},
+		{"let counter = 0; let inc = fn() { counter += 1 }; inc(); inc(); counter", 2},
+		{"let make = fn() { let c = 0; let inc = fn() { c += 1 }; inc(); inc(); c }; make()", 2},
+		{"let make = fn() { let c = 0; fn() { c += 1 } }; let inc = make(); inc(); inc()", 2},
+		{"let f = fn(x) { let g = fn() { x = x * 2 }; g(); x }; f(21)", 42},
+		{"let arr = [1, 2, 3]; arr[1] = 5; arr[1]", 5},
+		{"let arr = [1, 2, 3]; arr[2] *= 3; arr[2]", 9},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; h["a"] + h["b"]`, 5},
+		{"let arr = [1, 2, 3]; let f = fn(a) { a[0] = 10 }; f(arr); arr[0]", 10},
+		{"let x = 1; x += fn() { x = 10; 2 }(); x", 3},
+		{"let arr = [1]; arr[0] += fn() { arr[0] = 10; 2 }(); arr[0]", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestEvalAssignErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"x = 1", "identifier not found: x"},
+		{"let f = fn() { y = 1 }; f()", "identifier not found: y"},
+		{"len = 1", "cannot assign to len"},
+		{"if (false) { let z = 1 }; z = 2", "identifier not found: z"},
+		{"let x = true; x += 1", "type mismatch: BOOLEAN + INTEGER"},
+		{"let arr = [1]; arr[1] = 2", "index out of range: 1"},
+		{`let arr = [1]; arr["a"] = 2`, "index must be an integer: STRING"},
+		{`let h = {}; h[[1]] = 2`, "unhashable key: ARRAY"},
+		{"let x = 1; x[0] = 2", "index assignment not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
 func TestEvalReturnStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -148,6 +375,18 @@ func TestEvalErrorHandling(t *testing.T) {
 			"5 + true;",
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			"fn(x) { x } + 1",
+			"type mismatch: FUNCTION + INTEGER",
+		},
+		{
+			"if (false) { let a = 1 }; a",
+			"identifier not found: a",
+		},
+		{
+			"let f = fn() { if (false) { let a = 1 }; let h = fn() { a = 2 }; a }; f()",
+			"identifier not found: a",
+		},
 		{
 			"5 + true; 5;",
 			"type mismatch: INTEGER + BOOLEAN",
@@ -156,6 +395,62 @@ func TestEvalErrorHandling(t *testing.T) {
 			"-true",
 			"unknown operator: -BOOLEAN",
 		},
+		{
+			"5 % 0",
+			"division by zero",
+		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"let zero = 0; 10 / zero",
+			"division by zero",
+		},
+		{
+			"(2 ** 64) / 0",
+			"division by zero",
+		},
+		{
+			"(2 ** 64) % 0",
+			"division by zero",
+		},
+		{
+			"1.5 / 0",
+			"division by zero",
+		},
+		{
+			"1 / 0.0",
+			"division by zero",
+		},
+		{
+			"5.5 % 0.0",
+			"division by zero",
+		},
+		{
+			"let zero = -0.0; 10 % zero",
+			"division by zero",
+		},
+		{
+			`"a ${1 + true} b"`,
+			"type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"2 ** -1",
+			"negative exponent: -1",
+		},
+		{
+			"2 ** 99999999",
+			"exponent too large: 99999999",
+		},
+		{
+			"let arr = [1]; arr[2 ** 64] = 2",
+			"index out of range: 18446744073709551616",
+		},
+		{
+			"true && (1 + true)",
+			"type mismatch: INTEGER + BOOLEAN",
+		},
 		{
 			"true + false;",
 			"unknown operator: BOOLEAN + BOOLEAN",
@@ -188,6 +483,9 @@ func TestEvalErrorHandling(t *testing.T) {
 		},
 		{`{"a": 1}[fn(x) { x }]`, "unhashable key: FUNCTION"},
 		{"1[0]", "index operator not supported: INTEGER"},
+		{"fn() { 1; }(1);", "wrong number of arguments: want=0, got=1"},
+		{"fn(a) { a; }();", "wrong number of arguments: want=1, got=0"},
+		{"fn(a, b) { a + b; }(1);", "wrong number of arguments: want=2, got=1"},
 	}
 
 	for _, tt := range tests {
@@ -207,6 +505,87 @@ func TestEvalErrorHandling(t *testing.T) {
 	}
 }
 
+func TestEvalRecoversFromPanics(t *testing.T) {
+	// A prefix expression without an operand can not be produced by the parser so the evaluator does not guard against it
+	node := &ast.PrefixExpression{Operator: "-"}
+
+	evaluated := Eval(node, object.NewEnvironment())
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	if !strings.HasPrefix(errObj.Message, "internal error: ") {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestEvalErrorPosition(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedPosition string
+	}{
+		{"5 + true;", "1:3"},
+		{"let x = 1;\nlet y = x + true;", "2:11"},
+		{"let f = fn(a) {\n  -a\n};\nf(true);", "2:3"},
+		{"len(1)", "1:4"},
+		{"1;\n  len += 1", "2:3"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Pos.String() != tt.expectedPosition {
+			t.Errorf("wrong error position. expected=%q, got=%q", tt.expectedPosition, errObj.Pos)
+		}
+	}
+}
+
+func TestEvalErrorStackTrace(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStack []string
+	}{
+		{"5 + true;", []string{}},
+		{"len(1);", []string{"len (1:4)"}},
+		{
+			"let inner = fn(x) { x + true };\nlet outer = fn(x) { inner(x) };\nouter(1);",
+			[]string{"inner (2:26)", "outer (3:6)"},
+		},
+		{"let apply = fn(f) { f() };\napply(fn() { -true });", []string{"f (1:22)", "apply (2:6)"}},
+		{"fn() { first(1) }();", []string{"first (1:13)", "<anonymous> (1:18)"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if len(errObj.Stack) != len(tt.expectedStack) {
+			t.Errorf("wrong stack trace length for %q. expected=%d, got=%d (%v)",
+				tt.input, len(tt.expectedStack), len(errObj.Stack), errObj.Stack)
+			continue
+		}
+
+		for i, expected := range tt.expectedStack {
+			if errObj.Stack[i].String() != expected {
+				t.Errorf("wrong stack frame %d for %q. expected=%q, got=%q", i, tt.input, expected, errObj.Stack[i])
+			}
+		}
+	}
+}
+
 func TestEvalLetStatement(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -301,6 +680,11 @@ func TestEvalStringObject(t *testing.T) {
 
 		{`"Hello, World!"`, "Hello, World!"},
 		{`"Hello" + ", " + "World!"`, "Hello, World!"},
+		{`"a\tb\n\"c\"\u{e9}"`, "a\tb\n\"c\"é"},
+		{"`raw ${x}\\n`", "raw ${x}\\n"},
+		{`"1 + 2 = ${1 + 2}!"`, "1 + 2 = 3!"},
+		{`let name = "monkey"; "Hello, ${name}! ${[1, true]} ${"${name}s"}"`, "Hello, monkey! [1, true] monkeys"},
+		{`let f = fn(x) { "${x * 2}" }; f(2) + f(3)`, "46"},
 	}
 
 	for _, tt := range tests {
@@ -316,6 +700,9 @@ func TestEvalBuiltinFunctionLen(t *testing.T) {
 	}{
 		{`len("")`, 0},
 		{`len("Hello, World!")`, len("Hello, World!")},
+		{`len("héllo 世界")`, 13},
+		{`runelen("héllo 世界")`, 8},
+		{`runelen(1)`, "argument to `runelen` not supported. got=INTEGER"},
 		{`len(1)`, "argument to `len` not supported. got=INTEGER"},
 		{`len()`, "wrong number of arguments. got=0, want=1"},
 		{`len("1", "2")`, "wrong number of arguments. got=2, want=1"},
@@ -753,6 +1140,11 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 		return false
 	}
 
+	if result.IsBig() {
+		t.Errorf("result was not demoted to a small integer. got=%s", result.Inspect())
+		return false
+	}
+
 	if result.Value != expected {
 		t.Errorf("result.Value is not equal to %d. got=%d", expected, result.Value)
 		return false
@@ -761,6 +1153,21 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not of type *object.Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("result.Value is not equal to %g. got=%g", expected, result.Value)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -799,3 +1206,71 @@ func testStringObject(t *testing.T, obj object.Object, expected string) bool {
 
 	return true
 }
+
+func TestEvalLimits(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"while (true) { }", "step budget exhausted"},
+		{"let f = fn() { f() }; f();", "stack overflow: more than 1024 nested function calls"},
+		{"let x = 3; while (true) { x = x * x }", "integer too large: more than 1048576 bits"},
+		{`let x = "ab"; while (true) { x = x + x }`, "string too long: more than 16777216 bytes"},
+		{`let x = "ab"; while (true) { x = "${x}${x}" }`, "string too long: more than 16777216 bytes"},
+		{`let a = [1]; let i = 0; while (i < 40) { a = [a, a]; i += 1 }; "${a}"`, "string too long: more than 16777216 bytes"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetStepBudget(100000)
+
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func FuzzEval(f *testing.F) {
+	f.Add("let add = fn(x, y) { x + y; }; add(1, 2);")
+	f.Add(`let h = {"a": [1, 2.5], true: "${1 + 1}"}; h["a"][0] += 1; h`)
+	f.Add("let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10);")
+	f.Add("let x = 0; for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } x += i; } x")
+	f.Add(`quote(unquote(1 + 2) + 3); rest(push([1], "a")); 2 ** 70 / 3; -(0 - 9223372036854775807 - 1)`)
+	f.Add(`puts("a", [1]); quit(); 1`)
+	stubBuiltins(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+
+		// The budget keeps programs that never terminate from stalling the fuzzer
+		env := object.NewEnvironment()
+		env.SetStepBudget(10000)
+
+		evaluated := Eval(program, env)
+
+		if errObj, ok := evaluated.(*object.Error); ok && strings.HasPrefix(errObj.Message, "internal error: ") {
+			t.Fatalf("evaluating %q panicked: %s", input, errObj.Message)
+		}
+	})
+}
+
+// stubBuiltins keeps the programs being fuzzed from ending the fuzzer with quit or flooding its output with puts.
+func stubBuiltins(f *testing.F) {
+	exit, stdout := object.Exit, object.Stdout
+	f.Cleanup(func() { object.Exit, object.Stdout = exit, stdout })
+
+	object.Exit = func(int) {}
+	object.Stdout = io.Discard
+}
@@ -32,8 +32,9 @@ func DefineMacros(program *ast.Program, env *object.Environment) {
 		}
 	}
 
-	// Remove all macro nodes from the AST
-	for _, idx := range indexes {
+	// Remove all macro nodes from the AST, starting from the last so that removing one does not shift the others
+	for i := len(indexes) - 1; i >= 0; i-- {
+		idx := indexes[i]
 		program.Statements = append(program.Statements[:idx], program.Statements[idx+1:]...)
 	}
 }
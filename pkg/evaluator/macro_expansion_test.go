@@ -121,3 +121,23 @@ func testParseProgram(input string) *ast.Program {
 	p := parser.New(l)
 	return p.ParseProgram()
 }
+
+func TestDefineConsecutiveMacros(t *testing.T) {
+	input := `
+	let first = macro(x) { x };
+	let second = macro(x) { x };
+	let number = 1;
+	`
+
+	program := testParseProgram(input)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("the number of statements if not equal to 1. got=%d", len(program.Statements))
+	}
+
+	if program.Statements[0].String() != "let number = 1;" {
+		t.Fatalf("the wrong statement was removed. got=%s", program.Statements[0].String())
+	}
+}
@@ -24,7 +24,7 @@ func evalUnquoteCalls(quote ast.Node, env *object.Environment) ast.Node {
 			return node
 		}
 
-		eval := Eval(call.Arguments[0], env)
+		eval := evalNode(call.Arguments[0], env)
 
 		convertible, ok := eval.(object.Convertible)
 		if !ok {
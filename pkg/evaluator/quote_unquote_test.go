@@ -74,3 +74,26 @@ func TestQuoteUnqote(t *testing.T) {
 		}
 	}
 }
+
+func TestQuoteWrongNumberOfArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"quote()", "wrong number of arguments to `quote`. got=0, want=1"},
+		{"quote(1, 2)", "wrong number of arguments to `quote`. got=2, want=1"},
+	}
+
+	for _, test := range tests {
+		eval := testEval(test.input)
+		err, ok := eval.(*object.Error)
+
+		if !ok {
+			t.Fatalf("expected *object.Error. got=%T (%+v)", eval, eval)
+		}
+
+		if err.Message != test.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", test.expected, err.Message)
+		}
+	}
+}
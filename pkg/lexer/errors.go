@@ -0,0 +1,20 @@
+package lexer
+
+import (
+	"fmt"
+
+	"github.com/grantwforsythe/monkeylang/pkg/token"
+)
+
+// Error represents a problem found while tokenizing the input, e.g. a string that is never closed.
+type Error struct {
+	Pos     token.Position // Pos represents the position of the offending character.
+	Message string         // Message represents a human readable description of the error.
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
@@ -2,49 +2,105 @@
 package lexer
 
 import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
 	"github.com/grantwforsythe/monkeylang/pkg/strings"
 	"github.com/grantwforsythe/monkeylang/pkg/token"
 )
 
-// TODO: Fully support on Unicode and UTF-8 characters (See Chapter 1.3 for more info)
-
 // A structure representing a lexer.
 type Lexer struct {
 	input        string // The string to be tokenized
-	position     int    // current position in input (points to current char)
+	position     int    // current position in input (points to the first byte of the current char)
 	readPosition int    // current reading position in input (after current char)
-	ch           byte   // current char under examination
+	ch           rune   // current char under examination
+
+	file   string // The name of the file being tokenized, empty if the input did not come from a file
+	line   int    // The line of the current char
+	column int    // The column of the current char, counted in characters rather than bytes
+
+	keepComments bool // Whether comments are attached to the tokens that follow them
+	invalid      bool // Whether the current char is an invalid UTF-8 encoding which has not been reported yet
+
+	interpolations []interpolation // The interpolations, e.g. "${x}", being tokenized from the innermost outwards
+	errors         []*Error        // The errors found while tokenizing, in the order they were found
+}
+
+// interpolation represents an expression embedded in a string, e.g. "${x}", that is being tokenized.
+type interpolation struct {
+	depth int            // The number of braces opened by the expression that have not been closed yet
+	start token.Position // The position of the '"' that opened the string
 }
 
 // Create a new lexer.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFile("", input)
+}
+
+// Create a new lexer for the contents of a file. The name of the file is included in the position of every token.
+func NewWithFile(file string, input string) *Lexer {
+	l := &Lexer{input: input, file: file, line: 1}
 	l.readChar()
 	return l
 }
 
+// Errors gets the errors found while tokenizing, in the order they were found.
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
+// addError records an error found at the given position.
+func (l *Lexer) addError(pos token.Position, format string, a ...any) {
+	l.errors = append(l.errors, &Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+// KeepComments sets whether comments are attached to the tokens that follow them, e.g. so a formatter can preserve them.
+// Comments are discarded by default.
+func (l *Lexer) KeepComments(keep bool) {
+	l.keepComments = keep
+}
+
 // Get next character and advance the position in the input string.
+// A character is a UTF-8 encoded rune so it may span multiple bytes of the input.
 // If the current position is greater than the length of the input we've reached the end of the file.
 func (l *Lexer) readChar() {
+	// An invalid char is reported once it is consumed, rather than when it is read ahead at the end of the token before
+	// it, so that the error is found while tokenizing the token it belongs to
+	if l.invalid {
+		l.addError(l.currentPosition(), "invalid UTF-8 encoding")
+	}
+
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+	l.column += 1
+
+	size := 1
 	if l.readPosition >= len(l.input) {
 		// ASCII "NUL" -> "end of file" or "haven't read anything'"
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, size = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
+	l.invalid = l.ch == utf8.RuneError && size == 1
 
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += size
 }
 
 // Peek the next character without advancing the position of the input string.
 // If the current position is greater than the length of the input we've reached the end of the file.
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		// EOF
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+		return ch
 	}
 }
 
@@ -55,13 +111,105 @@ func (l *Lexer) skipWhiteSpace() {
 	}
 }
 
-// Read all consecutive digits.
-func (l *Lexer) readDigit() string {
+// Skip all the consecutive whitespaces and comments.
+// Returns the skipped comments if the lexer keeps them.
+func (l *Lexer) skipTrivia() (comments []token.Comment) {
+	for {
+		l.skipWhiteSpace()
+
+		if l.ch != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			return comments
+		}
+
+		pos := l.currentPosition()
+		position := l.position
+
+		if l.peekChar() == '/' {
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+		} else if !l.skipBlockComment() {
+			l.addError(pos, "unterminated block comment")
+			return comments
+		}
+
+		if l.keepComments {
+			comments = append(comments, token.Comment{Text: l.input[position:l.position], Pos: pos})
+		}
+	}
+}
+
+// Skip a block comment, including the closing "*/".
+// Returns false if the input ends before the comment is closed.
+func (l *Lexer) skipBlockComment() bool {
+	// Skip over the opening "/*"
+	l.readChar()
+	l.readChar()
+
+	for l.ch != 0 {
+		if l.ch == '*' && l.peekChar() == '/' {
+			l.readChar()
+			l.readChar()
+			return true
+		}
+		l.readChar()
+	}
+
+	return false
+}
+
+// Read a number, e.g. 42, 0xFF, 1_000 or 3.14e-2, returning whether it is an integer or a floating point number.
+// Underscores and the digits after a prefix are read as is, it is up to the parser to check that the number is well formed.
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	position := l.position
-	for strings.IsDigit(l.ch) {
+
+	if l.ch == '0' && isBasePrefix(l.peekChar()) {
+		// Skip over the prefix
+		l.readChar()
 		l.readChar()
+
+		for strings.IsHexDigit(l.ch) || l.ch == '_' {
+			l.readChar()
+		}
+		return token.INT, l.input[position:l.position]
 	}
-	return l.input[position:l.position]
+
+	var tokenType token.TokenType = token.INT
+	l.readDigits()
+
+	if l.ch == '.' && strings.IsDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if (l.ch == 'e' || l.ch == 'E') && l.isExponent() {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
+
+	return tokenType, l.input[position:l.position]
+}
+
+// Read all consecutive digits, including the underscores separating them.
+func (l *Lexer) readDigits() {
+	for strings.IsDigit(l.ch) || l.ch == '_' {
+		l.readChar()
+	}
+}
+
+// Check if the 'e' the lexer is on starts the exponent of a number, i.e. it is followed by digits with an optional sign.
+func (l *Lexer) isExponent() bool {
+	next := l.peekChar()
+	if next == '+' || next == '-' {
+		// The sign is a single byte so the digit is the byte after it
+		return l.readPosition+1 < len(l.input) && strings.IsDigit(rune(l.input[l.readPosition+1]))
+	}
+	return strings.IsDigit(next)
 }
 
 // Read an identifier and advance the lexer's postions until it encounters a non-letter character.
@@ -73,25 +221,133 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-// TODO: Add support for character escaping, e.g. \", \n, etc
+// Read the contents of a string, decoding any escape sequences, up to either its closing '"' or the start of an
+// interpolation, i.e. "${". The lexer must be on the '"' that opens the string, or the '}' that closes an interpolation.
+// Strings without interpolations are a single STRING token, otherwise they are split into a STRING_HEAD, a STRING_MIDDLE
+// between each interpolation, and a STRING_TAIL, with the tokens of the interpolated expressions in between.
+func (l *Lexer) readString(start token.Position, head bool) token.Token {
+	var out bytes.Buffer
+
+	for {
+		l.readChar()
+
+		switch {
+		case l.ch == '"':
+			if head {
+				return token.Token{Type: token.STRING, Literal: out.String()}
+			}
+			return token.Token{Type: token.STRING_TAIL, Literal: out.String()}
 
-// Read the contents of a string.
-func (l *Lexer) readString() string {
-	// Skip over the first '"'
+		case l.ch == 0:
+			l.addError(start, "unterminated string")
+			if head {
+				return token.Token{Type: token.STRING, Literal: out.String()}
+			}
+			return token.Token{Type: token.STRING_TAIL, Literal: out.String()}
+
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readChar()
+			l.interpolations = append(l.interpolations, interpolation{start: start})
+			if head {
+				return token.Token{Type: token.STRING_HEAD, Literal: out.String()}
+			}
+			return token.Token{Type: token.STRING_MIDDLE, Literal: out.String()}
+
+		case l.ch == '\\':
+			l.readEscape(&out)
+
+		default:
+			out.WriteRune(l.ch)
+		}
+	}
+}
+
+// Read an escape sequence, e.g. \n, and write the character it represents.
+// The lexer must be on the '\\' that starts the escape sequence and is left on its last character.
+func (l *Lexer) readEscape(out *bytes.Buffer) {
+	pos := l.currentPosition()
+	l.readChar()
+
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '"', '\\', '$':
+		out.WriteRune(l.ch)
+	case 'u':
+		l.readUnicodeEscape(pos, out)
+	case 0:
+		// The unterminated string is reported by the caller
+		return
+	default:
+		l.addError(pos, "unknown escape sequence: \\%c", l.ch)
+		out.WriteByte('\\')
+		out.WriteRune(l.ch)
+	}
+}
+
+// Read a unicode escape sequence, e.g. \u{1F600}, and write the UTF-8 encoding of the code point it represents.
+// The lexer must be on the 'u' of the escape sequence and is left on its closing '}'.
+func (l *Lexer) readUnicodeEscape(pos token.Position, out *bytes.Buffer) {
+	if l.peekChar() != '{' {
+		l.addError(pos, "invalid unicode escape: expected '{' after \\u")
+		return
+	}
+	l.readChar()
+
+	position := l.position + 1
+	for strings.IsHexDigit(l.peekChar()) {
+		l.readChar()
+	}
+	digits := l.input[position:l.readPosition]
+
+	if l.peekChar() != '}' {
+		l.addError(pos, "invalid unicode escape: expected '}' after \\u{%s", digits)
+		return
+	}
+	l.readChar()
+
+	codePoint, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(codePoint)) {
+		l.addError(pos, "invalid unicode escape: \\u{%s}", digits)
+		return
+	}
+
+	out.WriteRune(rune(codePoint))
+}
+
+// Read the contents of a raw string, i.e. one delimited by '`', in which escape sequences and interpolations are not
+// processed and which can span multiple lines.
+func (l *Lexer) readRawString(start token.Position) string {
+	// Skip over the first '`'
 	l.readChar()
 
 	position := l.position
-	for l.ch != '"' && l.ch != 0 {
+	for l.ch != '`' && l.ch != 0 {
 		l.readChar()
 	}
 
+	if l.ch == 0 {
+		l.addError(start, "unterminated raw string")
+	}
+
 	return l.input[position:l.position]
 }
 
+// Get the position of the current char.
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{File: l.file, Line: l.line, Column: l.column}
+}
+
 // Iterate to the next token.
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
-	l.skipWhiteSpace()
+
+	comments := l.skipTrivia()
+	pos := l.currentPosition()
 
 	switch l.ch {
 	case ':':
@@ -105,9 +361,22 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '{':
+		if len(l.interpolations) > 0 {
+			l.interpolations[len(l.interpolations)-1].depth++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
-		tok = newToken(token.RBRACE, l.ch)
+		if n := len(l.interpolations); n > 0 && l.interpolations[n-1].depth == 0 {
+			// The brace closes the interpolation so the rest of the string follows
+			start := l.interpolations[n-1].start
+			l.interpolations = l.interpolations[:n-1]
+			tok = l.readString(start, false)
+		} else {
+			if n > 0 {
+				l.interpolations[n-1].depth--
+			}
+			tok = newToken(token.RBRACE, l.ch)
+		}
 	case '=':
 		if l.peekChar() == '=' {
 			l.readChar()
@@ -123,20 +392,53 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newAssignableToken(token.PLUS, token.PLUS_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			l.readChar()
+			tok = token.Token{Type: token.POWER, Literal: "**"}
+		} else {
+			tok = l.newAssignableToken(token.ASTERISK, token.ASTERISK_ASSIGN)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: "&&"}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: "||"}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.newAssignableToken(token.SLASH, token.SLASH_ASSIGN)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: "<="}
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: ">="}
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newAssignableToken(token.MINUS, token.MINUS_ASSIGN)
 	case '"':
+		tok = l.readString(pos, true)
+	case '`':
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = l.readRawString(pos)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -148,11 +450,14 @@ func (l *Lexer) NextToken() token.Token {
 		if strings.IsLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
+			tok.Comments = comments
 			// Exit early because we do not want to call readChar twice
 			return tok
 		} else if strings.IsDigit(l.ch) {
-			tok.Literal = l.readDigit()
-			tok.Type = token.INT
+			tok.Type, tok.Literal = l.readNumber()
+			tok.Pos = pos
+			tok.Comments = comments
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -160,10 +465,33 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos = pos
+	tok.Comments = comments
 	return tok
 }
 
+// Create a token for an arithmetic operator, or its compound assignment form if it is followed by '=', e.g. "+=".
+func (l *Lexer) newAssignableToken(operator token.TokenType, assign token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: assign, Literal: string(ch) + string(l.ch)}
+	}
+
+	return newToken(operator, l.ch)
+}
+
+// Check if a character follows the '0' of a prefix which changes the base of an integer, e.g. 0x
+func isBasePrefix(ch rune) bool {
+	switch ch {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	default:
+		return false
+	}
+}
+
 // Create a new token
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
@@ -14,7 +14,7 @@ let add = fn(x, y) {
 	x + y;
 };
 let result = add(five, ten);
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 
 	if (5 < 10) {
@@ -156,3 +156,506 @@ let result = add(five, ten);
 		}
 	}
 }
+
+func TestNextTokenPosition(t *testing.T) {
+	input := "let x = 5;\n  x + 10;\n"
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.IDENT, 2, 3},
+		{token.PLUS, 2, 5},
+		{token.INT, 2, 7},
+		{token.SEMICOLON, 2, 9},
+		{token.EOF, 3, 1},
+	}
+
+	l := NewWithFile("test.monkey", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.File != "test.monkey" {
+			t.Fatalf("tests[%d] - file wrong. expected=%q, got=%q", i, "test.monkey", tok.Pos.File)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestNextTokenAssignmentOperators(t *testing.T) {
+	input := "x = 1; x += 2; x -= 3; x *= 4; x /= 5; x == x;"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "4"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.EQ, "=="},
+		{token.IDENT, "x"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextTokenOperators(t *testing.T) {
+	input := "a <= b >= c % d ** e * f && g || h & i | j"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.POWER, "**"},
+		{token.IDENT, "e"},
+		{token.ASTERISK, "*"},
+		{token.IDENT, "f"},
+		{token.AND, "&&"},
+		{token.IDENT, "g"},
+		{token.OR, "||"},
+		{token.IDENT, "h"},
+		{token.ILLEGAL, "&"},
+		{token.IDENT, "i"},
+		{token.ILLEGAL, "|"},
+		{token.IDENT, "j"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextTokenComments(t *testing.T) {
+	input := `// A line comment
+let x = 5; // A trailing comment
+/* A block
+   comment */ x /**/ / 2;
+/* An unterminated comment`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{token.LET, "let", 2, 1},
+		{token.IDENT, "x", 2, 5},
+		{token.ASSIGN, "=", 2, 7},
+		{token.INT, "5", 2, 9},
+		{token.SEMICOLON, ";", 2, 10},
+		{token.IDENT, "x", 4, 15},
+		{token.SLASH, "/", 4, 22},
+		{token.INT, "2", 4, 24},
+		{token.SEMICOLON, ";", 4, 25},
+		{token.EOF, "", 5, 27},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+
+		if len(tok.Comments) != 0 {
+			t.Fatalf("tests[%d] - comments were kept. got=%v", i, tok.Comments)
+		}
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. expected=1, got=%d", len(errors))
+	}
+
+	if errors[0].Error() != "5:1: unterminated block comment" {
+		t.Fatalf("wrong error. expected=%q, got=%q", "5:1: unterminated block comment", errors[0].Error())
+	}
+}
+
+func TestNextTokenKeepComments(t *testing.T) {
+	input := `// The answer
+let x = 42; /* Block */ // Line
+`
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedComments []token.Comment
+	}{
+		{token.LET, []token.Comment{{Text: "// The answer", Pos: token.Position{Line: 1, Column: 1}}}},
+		{token.IDENT, nil},
+		{token.ASSIGN, nil},
+		{token.INT, nil},
+		{token.SEMICOLON, nil},
+		{token.EOF, []token.Comment{
+			{Text: "/* Block */", Pos: token.Position{Line: 2, Column: 13}},
+			{Text: "// Line", Pos: token.Position{Line: 2, Column: 25}},
+		}},
+	}
+
+	l := New(input)
+	l.KeepComments(true)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if len(tok.Comments) != len(tt.expectedComments) {
+			t.Fatalf("tests[%d] - wrong number of comments. expected=%d, got=%d",
+				i, len(tt.expectedComments), len(tok.Comments))
+		}
+
+		for j, comment := range tt.expectedComments {
+			if tok.Comments[j] != comment {
+				t.Fatalf("tests[%d] - comments[%d] wrong. expected=%+v, got=%+v",
+					i, j, comment, tok.Comments[j])
+			}
+		}
+	}
+}
+
+func TestNextTokenStrings(t *testing.T) {
+	input := `"a\tb\n\"c\"\\ \$d \u{48}\u{e9}\u{1F600}"
+` + "`raw \\n ${x}\n`" + `
+"Hello, ${name}!"
+"${a}${ {"b": 1}["b"] } ${"c${d}"}"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "a\tb\n\"c\"\\ $d Hé😀"},
+		{token.STRING, "raw \\n ${x}\n"},
+		{token.STRING_HEAD, "Hello, "},
+		{token.IDENT, "name"},
+		{token.STRING_TAIL, "!"},
+		{token.STRING_HEAD, ""},
+		{token.IDENT, "a"},
+		{token.STRING_MIDDLE, ""},
+		{token.LBRACE, "{"},
+		{token.STRING, "b"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "b"},
+		{token.RBRACKET, "]"},
+		{token.STRING_MIDDLE, " "},
+		{token.STRING_HEAD, "c"},
+		{token.IDENT, "d"},
+		{token.STRING_TAIL, ""},
+		{token.STRING_TAIL, ""},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Fatalf("lexer has %d errors: %v", len(l.Errors()), l.Errors())
+	}
+}
+
+func TestNextTokenStringErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedErrors  []string
+	}{
+		{`"abc`, "abc", []string{"1:1: unterminated string"}},
+		{"x `abc", "abc", []string{"1:3: unterminated raw string"}},
+		{`"a\qb"`, `a\qb`, []string{`1:3: unknown escape sequence: \q`}},
+		{`"\u{110000}"`, "", []string{`1:2: invalid unicode escape: \u{110000}`}},
+		{`"\u{D800}"`, "", []string{`1:2: invalid unicode escape: \u{D800}`}},
+		{`"\u{}"`, "", []string{`1:2: invalid unicode escape: \u{}`}},
+		{`"\u41"`, "41", []string{`1:2: invalid unicode escape: expected '{' after \u`}},
+		{`"\u{41"`, "", []string{`1:2: invalid unicode escape: expected '}' after \u{41`}},
+		{`"\`, "", []string{"1:1: unterminated string"}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		var tok token.Token
+		for tok = l.NextToken(); tok.Type != token.STRING; tok = l.NextToken() {
+			if tok.Type == token.EOF {
+				t.Fatalf("no string token for %q", tt.input)
+			}
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("literal wrong for %q. expected=%q, got=%q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+
+		errors := l.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("wrong number of errors for %q. expected=%d, got=%d (%v)", tt.input, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+
+		for i, expected := range tt.expectedErrors {
+			if errors[i].Error() != expected {
+				t.Errorf("errors[%d] wrong for %q. expected=%q, got=%q", i, tt.input, expected, errors[i].Error())
+			}
+		}
+	}
+}
+
+func TestNextTokenUnicode(t *testing.T) {
+	input := "let café = \"héllo 世界\";\nλ + ü_2; 🐒"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1, 1},
+		{token.IDENT, "café", 1, 5},
+		{token.ASSIGN, "=", 1, 10},
+		{token.STRING, "héllo 世界", 1, 12},
+		{token.SEMICOLON, ";", 1, 22},
+		{token.IDENT, "λ", 2, 1},
+		{token.PLUS, "+", 2, 3},
+		{token.IDENT, "ü_", 2, 5},
+		{token.INT, "2", 2, 7},
+		{token.SEMICOLON, ";", 2, 8},
+		{token.ILLEGAL, "🐒", 2, 10},
+		{token.EOF, "", 2, 11},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestNextTokenInvalidUTF8(t *testing.T) {
+	l := New("\"a\xffb\"")
+
+	tok := l.NextToken()
+	if tok.Type != token.STRING {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.STRING, tok.Type)
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. expected=1, got=%d", len(errors))
+	}
+
+	if errors[0].Error() != "1:3: invalid UTF-8 encoding" {
+		t.Fatalf("wrong error. expected=%q, got=%q", "1:3: invalid UTF-8 encoding", errors[0].Error())
+	}
+}
+
+func TestNextTokenInvalidUTF8Token(t *testing.T) {
+	l := New("0!\xff")
+
+	// The error belongs to the invalid token rather than the token before it, which is lexed while reading ahead
+	for _, expected := range []token.TokenType{token.INT, token.BANG} {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("tokentype wrong. expected=%q, got=%q", expected, tok.Type)
+		}
+
+		if len(l.Errors()) != 0 {
+			t.Fatalf("error reported with %q: %v", tok.Literal, l.Errors())
+		}
+	}
+
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.ILLEGAL, tok.Type)
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. expected=1, got=%d", len(errors))
+	}
+
+	if errors[0].Error() != "1:3: invalid UTF-8 encoding" {
+		t.Fatalf("wrong error. expected=%q, got=%q", "1:3: invalid UTF-8 encoding", errors[0].Error())
+	}
+}
+
+func TestNextTokenNumbers(t *testing.T) {
+	input := "42 3.14 1e9 2.5E-3 1e+2 0xFF 0o17 0b1010 1_000 0x_ff_ff 1.foo 2e"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "42"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "1e+2"},
+		{token.INT, "0xFF"},
+		{token.INT, "0o17"},
+		{token.INT, "0b1010"},
+		{token.INT, "1_000"},
+		{token.INT, "0x_ff_ff"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "foo"},
+		{token.INT, "2"},
+		{token.IDENT, "e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func FuzzNextToken(f *testing.F) {
+	f.Add("let five = 5; let add = fn(x, y) { x + y; };")
+	f.Add(`"Hello, ${name}!" "a\n\t\u{1F412}" ` + "`raw`")
+	f.Add("0xFF 0o17 0b1010 1_000 3.14 2.5e-3 // comment\n/* block */")
+	f.Add("\xff\xfe \"${\"${")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		l := New(input)
+
+		// Every token other than EOF consumes at least one character, so the lexer can not get stuck
+		for i := 0; i <= len(input); i++ {
+			tok := l.NextToken()
+			if tok.Type == token.EOF {
+				return
+			}
+
+			if !tok.Pos.IsValid() {
+				t.Fatalf("token %q has an invalid position", tok.Literal)
+			}
+		}
+
+		t.Fatalf("no EOF after %d tokens", len(input)+1)
+	})
+}
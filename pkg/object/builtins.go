@@ -0,0 +1,210 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	// Stdout represents where puts writes to, it can be replaced to capture or discard the output, e.g. in tests.
+	Stdout io.Writer = os.Stdout
+
+	// Exit represents the function quit ends the process with, it can be replaced so that a program can not end its host,
+	// e.g. a fuzzer. quit evaluates to null if Exit returns.
+	Exit = os.Exit
+)
+
+// Builtins is the registry of all builtin functions shared by the evaluator and the virtual machine.
+// The compiler refers to a builtin by its index in the registry so new builtins must be appended to the end.
+// A builtin that returns nil evaluates to null.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{
+			// Calculate the length of array or string, the length of a string is its number of bytes.
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Array:
+					return &Integer{Value: int64(len(arg.Elements))}
+				case *String:
+					return &Integer{Value: int64(len(arg.Value))}
+				default:
+					return newError("argument to `len` not supported. got=%s", args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		"first",
+		&Builtin{
+			// Get the first element of an array.
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+
+				array, ok := args[0].(*Array)
+				if !ok {
+					return newError(
+						"'first' only accepts an array as an argument. got=%s",
+						args[0].Type(),
+					)
+				}
+
+				if len(array.Elements) == 0 {
+					return nil
+				}
+
+				return array.Elements[0]
+			},
+		},
+	},
+	{
+		"last",
+		&Builtin{
+			// Get the last element of an array.
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+
+				array, ok := args[0].(*Array)
+				if !ok {
+					return newError(
+						"'last' only accepts an array as an argument. got=%s",
+						args[0].Type(),
+					)
+				}
+
+				length := len(array.Elements)
+				if length == 0 {
+					return nil
+				}
+
+				return array.Elements[length-1]
+			},
+		},
+	},
+	{
+		"rest",
+		&Builtin{
+			// Return a copy of the array with the first element removed.
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+
+				array, ok := args[0].(*Array)
+				if !ok {
+					return newError(
+						"'rest' only accepts an array as an argument. got=%s",
+						args[0].Type(),
+					)
+				}
+
+				length := len(array.Elements)
+				if length == 0 {
+					return nil
+				}
+
+				elements := make([]Object, length-1)
+
+				if length == 1 {
+					return &Array{Elements: elements}
+				}
+
+				copy(elements, array.Elements[1:])
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		"push",
+		&Builtin{
+			// Return a cloned array with a new value appended to it.
+			Fn: func(args ...Object) Object {
+				if len(args) < 2 {
+					return newError("wrong number of arguments. got=%d, want=>2", len(args))
+				}
+
+				array, ok := args[0].(*Array)
+				if !ok {
+					return newError(
+						"the first argument needs to be of type ARRAY. got=%s",
+						args[0].Type(),
+					)
+				}
+
+				length := len(array.Elements)
+
+				elements := make([]Object, length)
+				copy(elements, array.Elements)
+				elements = append(elements, args[1:]...)
+
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		"quit",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				Exit(0)
+				return nil
+			},
+		},
+	},
+	{
+		"puts",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				for _, arg := range args {
+					fmt.Fprintln(Stdout, arg.Inspect())
+				}
+				return nil
+			},
+		},
+	},
+	{
+		"runelen",
+		&Builtin{
+			// Calculate the number of characters in a string, which is less than its length when it contains multibyte characters.
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+
+				str, ok := args[0].(*String)
+				if !ok {
+					return newError("argument to `runelen` not supported. got=%s", args[0].Type())
+				}
+
+				return &Integer{Value: int64(str.RuneCount())}
+			},
+		},
+	},
+}
+
+// GetBuiltinByName gets a builtin function from the registry.
+// Returns nil if there is no builtin with the given name.
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+
+	return nil
+}
+
+func newError(format string, a ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
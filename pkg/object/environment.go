@@ -2,20 +2,62 @@ package object
 
 // Environment represents the scope of a program.
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	outer  *Environment
+	limits *limits // The limits are shared by a global environment and every environment enclosed by it
+}
+
+// limits bounds the resources used while evaluating a program.
+type limits struct {
+	steps     int  // The number of steps left before evaluation is stopped
+	limited   bool // Whether the number of steps is limited at all
+	callDepth int  // The current depth of nested function calls
 }
 
 // NewEnvironment creates a new global environment.
 func NewEnvironment() *Environment {
 	store := make(map[string]Object)
-	return &Environment{store: store, outer: nil}
+	return &Environment{store: store, outer: nil, limits: &limits{}}
 }
 
 // NewEnclosedEnvironment creates a new enclosed environment.
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	store := make(map[string]Object)
-	return &Environment{store: store, outer: outer}
+	return &Environment{store: store, outer: outer, limits: outer.limits}
+}
+
+// SetStepBudget limits the number of steps, e.g. the number of nodes evaluated, that may be taken in the environment
+// and every environment enclosed by it. This guarantees that evaluating an untrusted program terminates.
+func (e *Environment) SetStepBudget(steps int) {
+	e.limits.steps = steps
+	e.limits.limited = true
+}
+
+// Step uses up one step of the step budget.
+// Returns false if the budget has already been used up.
+func (e *Environment) Step() bool {
+	if !e.limits.limited {
+		return true
+	}
+
+	if e.limits.steps <= 0 {
+		return false
+	}
+
+	e.limits.steps--
+	return true
+}
+
+// EnterCall records that a function is being called and gets the resulting depth of nested function calls.
+// Every call to EnterCall must be paired with a call to LeaveCall once the function returns.
+func (e *Environment) EnterCall() int {
+	e.limits.callDepth++
+	return e.limits.callDepth
+}
+
+// LeaveCall records that a function has returned.
+func (e *Environment) LeaveCall() {
+	e.limits.callDepth--
 }
 
 // Get gets the value associated with the identifier.
@@ -28,6 +70,21 @@ func (e *Environment) Get(identifier string) (Object, bool) {
 	return obj, ok
 }
 
+// Assign updates the value of an existing identifier in the environment it was defined in, which may be an enclosing one.
+// Returns false if the identifier has not been defined.
+func (e *Environment) Assign(identifier string, value Object) (Object, bool) {
+	if _, ok := e.store[identifier]; ok {
+		e.store[identifier] = value
+		return value, true
+	}
+
+	if e.outer == nil {
+		return nil, false
+	}
+
+	return e.outer.Assign(identifier, value)
+}
+
 // Set stores a value in the environment for the given identifier.
 func (e *Environment) Set(identifier string, value Object) Object {
 	e.store[identifier] = value
@@ -0,0 +1,182 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// MaxIntegerBits represents the maximum number of bits in the result of multiplying an integer or raising it to a power.
+// Without a limit a small mistake, e.g. 2 ** 99999999999, would exhaust the memory of the host.
+const MaxIntegerBits = 1 << 20
+
+// NewBigInteger creates an integer from an arbitrary-precision value, it is only kept as a big integer if it does not
+// fit in 64 bits.
+func NewBigInteger(value *big.Int) *Integer {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &Integer{Big: value}
+}
+
+// IsBig checks if the integer has been promoted because it does not fit in 64 bits.
+func (i *Integer) IsBig() bool {
+	return i.Big != nil
+}
+
+// BigInt gets the value of the integer as an arbitrary-precision integer, the result must not be modified.
+func (i *Integer) BigInt() *big.Int {
+	if i.Big != nil {
+		return i.Big
+	}
+	return big.NewInt(i.Value)
+}
+
+// Float converts the integer to the closest float.
+func (i *Integer) Float() float64 {
+	if i.Big != nil {
+		f, _ := new(big.Float).SetInt(i.Big).Float64()
+		return f
+	}
+	return float64(i.Value)
+}
+
+// Sign gets -1 if the integer is negative, 0 if it is zero, and +1 if it is positive.
+func (i *Integer) Sign() int {
+	if i.Big != nil {
+		return i.Big.Sign()
+	}
+
+	switch {
+	case i.Value < 0:
+		return -1
+	case i.Value > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Cmp compares two integers, returning -1 if the integer is less than the other, 0 if they are equal, and +1 if it is
+// greater than the other.
+func (i *Integer) Cmp(other *Integer) int {
+	if i.Big == nil && other.Big == nil {
+		switch {
+		case i.Value < other.Value:
+			return -1
+		case i.Value > other.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return i.BigInt().Cmp(other.BigInt())
+}
+
+// Neg negates the integer.
+func (i *Integer) Neg() *Integer {
+	if i.Big == nil && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	return NewBigInteger(new(big.Int).Neg(i.BigInt()))
+}
+
+// Add adds two integers, promoting the sum to a big integer if it overflows.
+func (i *Integer) Add(other *Integer) *Integer {
+	if i.Big == nil && other.Big == nil {
+		sum := i.Value + other.Value
+		// The sum can only overflow if both operands have the same sign, in which case the sign of the sum flips
+		if (i.Value >= 0) != (other.Value >= 0) || (sum >= 0) == (i.Value >= 0) {
+			return &Integer{Value: sum}
+		}
+	}
+	return NewBigInteger(new(big.Int).Add(i.BigInt(), other.BigInt()))
+}
+
+// Sub subtracts the other integer from the integer, promoting the difference to a big integer if it overflows.
+func (i *Integer) Sub(other *Integer) *Integer {
+	if i.Big == nil && other.Big == nil {
+		diff := i.Value - other.Value
+		// The difference can only overflow if the operands have different signs, in which case its sign differs from the first
+		if (i.Value >= 0) == (other.Value >= 0) || (diff >= 0) == (i.Value >= 0) {
+			return &Integer{Value: diff}
+		}
+	}
+	return NewBigInteger(new(big.Int).Sub(i.BigInt(), other.BigInt()))
+}
+
+// Mul multiplies two integers, promoting the product to a big integer if it overflows.
+// Returns false if the product could have more than MaxIntegerBits bits.
+func (i *Integer) Mul(other *Integer) (*Integer, bool) {
+	if i.Big == nil && other.Big == nil {
+		a, b := i.Value, other.Value
+		if a == 0 || b == 0 {
+			return &Integer{Value: 0}, true
+		}
+
+		product := a * b
+		// Dividing the product by one operand gives the other unless it overflowed, MinInt64 * -1 is the exception
+		// because MinInt64 / -1 overflows as well
+		if product/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64) {
+			return &Integer{Value: product}, true
+		}
+	}
+
+	// The product has at most as many bits as both operands together
+	if i.BigInt().BitLen()+other.BigInt().BitLen() > MaxIntegerBits {
+		return nil, false
+	}
+	return NewBigInteger(new(big.Int).Mul(i.BigInt(), other.BigInt())), true
+}
+
+// Quo divides the integer by the other integer, truncating towards zero. The other integer must not be zero.
+func (i *Integer) Quo(other *Integer) *Integer {
+	if i.Big == nil && other.Big == nil && !(i.Value == math.MinInt64 && other.Value == -1) {
+		return &Integer{Value: i.Value / other.Value}
+	}
+	return NewBigInteger(new(big.Int).Quo(i.BigInt(), other.BigInt()))
+}
+
+// Rem gets the remainder of dividing the integer by the other integer, which has the sign of the integer.
+// The other integer must not be zero.
+func (i *Integer) Rem(other *Integer) *Integer {
+	if i.Big == nil && other.Big == nil {
+		return &Integer{Value: i.Value % other.Value}
+	}
+	return NewBigInteger(new(big.Int).Rem(i.BigInt(), other.BigInt()))
+}
+
+// Pow raises the integer to the power of a non-negative exponent using exponentiation by squaring.
+// Returns false if the result could have more than MaxIntegerBits bits.
+func (i *Integer) Pow(exponent *Integer) (*Integer, bool) {
+	// Powers of 0, 1 and -1 never grow so they are the only ones that can be raised to a big exponent
+	if i.Big == nil && i.Value >= -1 && i.Value <= 1 {
+		if exponent.Sign() == 0 {
+			return &Integer{Value: 1}, true
+		}
+		if i.Value == -1 && exponent.BigInt().Bit(0) == 0 {
+			return &Integer{Value: 1}, true
+		}
+		return i, true
+	}
+
+	if exponent.Big != nil || exponent.Value > MaxIntegerBits || int64(i.BigInt().BitLen()-1)*exponent.Value > MaxIntegerBits {
+		return nil, false
+	}
+
+	var ok bool
+	result, base := &Integer{Value: 1}, i
+	for e := exponent.Value; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			if result, ok = result.Mul(base); !ok {
+				return nil, false
+			}
+		}
+		if e > 1 {
+			if base, ok = base.Mul(base); !ok {
+				return nil, false
+			}
+		}
+	}
+
+	return result, true
+}
@@ -5,10 +5,15 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/grantwforsythe/monkeylang/pkg/ast"
+	"github.com/grantwforsythe/monkeylang/pkg/code"
 	"github.com/grantwforsythe/monkeylang/pkg/token"
 )
 
@@ -16,9 +21,12 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
@@ -27,6 +35,9 @@ const (
 	HASH_OBJ         = "HASH"
 	QUOTE_OBJ        = "QUOTE"
 	MACRO_OBJ        = "MACRO"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -55,19 +66,57 @@ type Hashable interface {
 	HashKey() HashKey
 }
 
+// An integer of arbitrary size, the arithmetic for which is in integer.go.
+// Integers that fit in 64 bits are stored in Value, larger ones are promoted to Big so that they never overflow.
 type Integer struct {
-	Value int64
+	Value int64    // The value of the integer, only meaningful if Big is nil
+	Big   *big.Int // The value of the integer if it does not fit in 64 bits, nil otherwise
 }
 
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
-func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
+func (i *Integer) Inspect() string {
+	if i.Big != nil {
+		return i.Big.String()
+	}
+	return strconv.FormatInt(i.Value, 10)
+}
 func (i *Integer) HashKey() HashKey {
+	if i.Big != nil {
+		h := fnv.New64a()
+		h.Write([]byte(i.Big.String()))
+
+		return HashKey{Type: i.Type(), Value: h.Sum64()}
+	}
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 func (i *Integer) ToNode() ast.Node {
 	return &ast.IntegerLiteral{
-		Token: token.Token{Type: token.INT, Literal: strconv.FormatInt(i.Value, 10)},
+		Token: token.Token{Type: token.INT, Literal: i.Inspect()},
 		Value: i.Value,
+		Big:   i.Big,
+	}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	str := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	// Keep the decimal point of whole numbers so they can be told apart from integers, e.g. 2.0 rather than 2
+	if !strings.ContainsAny(str, ".eIN") {
+		str += ".0"
+	}
+	return str
+}
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+func (f *Float) ToNode() ast.Node {
+	return &ast.FloatLiteral{
+		Token: token.Token{Type: token.FLOAT, Literal: f.Inspect()},
+		Value: f.Value,
 	}
 }
 
@@ -101,6 +150,30 @@ func (b *Boolean) ToNode() ast.Node {
 	}
 }
 
+// IsTruthy determines if an object is truthy, this is the one definition shared by every backend.
+// Null, false, the integer 0, the float 0.0, the empty string, the empty array and the empty hash are falsy.
+// Every other object is truthy, including negative numbers and functions.
+func IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Null:
+		return false
+	case *Boolean:
+		return obj.Value
+	case *Integer:
+		return obj.Sign() != 0
+	case *Float:
+		return obj.Value != 0
+	case *String:
+		return obj.Value != ""
+	case *Array:
+		return len(obj.Elements) != 0
+	case *Hash:
+		return len(obj.Pairs) != 0
+	default:
+		return true
+	}
+}
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
@@ -113,16 +186,91 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
-// TODO: Add a stacktrace
-// TODO: Add line and column number
+// Break signals that the enclosing loop should stop, it is never bound to a name.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+// Continue signals that the enclosing loop should skip to its next iteration, it is never bound to a name.
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
 type Error struct {
 	Message string
+	Pos     token.Position // The position of the node that caused the error
+	Stack   []StackFrame   // The function calls being made when the error occurred, from the innermost call outwards
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return fmt.Sprintf("Error: %s", e.Message) }
+func (e *Error) Inspect() string {
+	var out bytes.Buffer
+
+	out.WriteString("Error: " + e.Error())
+	for _, line := range e.StackTrace() {
+		out.WriteString("\n\t" + line)
+	}
+
+	return out.String()
+}
+
+// maxStackTraceLines represents the maximum number of lines in a stack trace, a stack overflow has a frame for every
+// one of the nested calls.
+const maxStackTraceLines = 20
+
+// StackTrace formats the stack of the error with one line per frame, from the innermost call outwards. A frame that is
+// repeated, i.e. by a recursive call, is only shown once followed by the number of repetitions, and the trace is cut
+// short after maxStackTraceLines lines.
+func (e *Error) StackTrace() []string {
+	lines := []string{}
+
+	for i := 0; i < len(e.Stack); {
+		if len(lines) >= maxStackTraceLines {
+			lines = append(lines, fmt.Sprintf("... %d more", len(e.Stack)-i))
+			break
+		}
+
+		repeated := 1
+		for i+repeated < len(e.Stack) && e.Stack[i+repeated] == e.Stack[i] {
+			repeated++
+		}
+
+		lines = append(lines, "at "+e.Stack[i].String())
+		if repeated > 1 {
+			lines = append(lines, fmt.Sprintf("... %d more", repeated-1))
+		}
+
+		i += repeated
+	}
+
+	return lines
+}
+
+// Error formats the message prefixed with the position of the node that caused the error, if it is known.
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// StackFrame represents a single function call in the stack trace of an error.
+type StackFrame struct {
+	Function string         // The name of the function being called
+	Pos      token.Position // The position of the call
+}
+
+func (sf StackFrame) String() string {
+	if !sf.Pos.IsValid() {
+		return sf.Function
+	}
+	return fmt.Sprintf("%s (%s)", sf.Function, sf.Pos)
+}
 
 type Function struct {
+	Name       string // The name the function was bound to when it was defined, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -130,29 +278,34 @@ type Function struct {
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
-	var out bytes.Buffer
-
+	// Only the parameters are shown since a compiled function, see Closure, no longer has its body
 	params := []string{}
 	for _, param := range f.Parameters {
 		params = append(params, param.String())
 	}
 
-	out.WriteString("fn")
-	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") \n")
-	out.WriteString(f.Body.String())
-	out.WriteString("\n")
+	return inspectFunction(params)
+}
 
-	return out.String()
+func inspectFunction(params []string) string {
+	return "fn(" + strings.Join(params, ", ") + ")"
 }
 
+// MaxStringLength represents the maximum length in bytes of a string built by concatenation or interpolation.
+// Interpolation builds the representations of its parts with InspectLimited so that the limit is enforced as they are
+// built rather than afterwards.
+// Without a limit a loop that doubles a string would exhaust the memory of the host long before it ran out of steps.
+const MaxStringLength = 1 << 24
+
 type String struct {
 	Value string
 }
 
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
+
+// RuneCount gets the number of characters in the string, unlike the length of its value which is the number of bytes.
+func (s *String) RuneCount() int { return utf8.RuneCountInString(s.Value) }
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -176,18 +329,8 @@ type Array struct {
 
 func (a *Array) Type() ObjectType { return ARRAY_OBJ }
 func (a *Array) Inspect() string {
-	var out bytes.Buffer
-
-	elements := []string{}
-	for _, element := range a.Elements {
-		elements = append(elements, element.Inspect())
-	}
-
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
-
-	return out.String()
+	s, _ := InspectLimited(a, math.MaxInt)
+	return s
 }
 
 type HashPair struct {
@@ -201,18 +344,72 @@ type Hash struct {
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
-	var out bytes.Buffer
+	s, _ := InspectLimited(h, math.MaxInt)
+	return s
+}
 
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+// InspectLimited gets the string representation of an object like Inspect, but gives up as soon as it is longer than
+// limit bytes. An array can contain the same array many times over, e.g. after repeatedly doing a = [a, a], so its
+// representation can be far too large to ever build even though the array itself is small.
+// Returns false if the representation is longer than limit.
+func InspectLimited(obj Object, limit int) (string, bool) {
+	var out strings.Builder
+	if !inspect(&out, obj, limit) {
+		return "", false
 	}
 
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
+	return out.String(), true
+}
 
-	return out.String()
+// inspect writes the string representation of an object to out, stopping as soon as out is longer than limit bytes.
+// Returns false if out is longer than limit.
+func inspect(out *strings.Builder, obj Object, limit int) bool {
+	switch obj := obj.(type) {
+	case *Array:
+		out.WriteString("[")
+		for i, element := range obj.Elements {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+
+			if !inspect(out, element, limit) {
+				return false
+			}
+		}
+		out.WriteString("]")
+	case *Hash:
+		remaining := limit - out.Len()
+
+		pairs := make([]string, 0, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			var p strings.Builder
+			if !inspect(&p, pair.Key, remaining) {
+				return false
+			}
+			p.WriteString(": ")
+			if !inspect(&p, pair.Value, remaining) {
+				return false
+			}
+
+			remaining -= p.Len()
+			if remaining < 0 {
+				return false
+			}
+			pairs = append(pairs, p.String())
+		}
+		// The pairs are sorted so that a hash is always printed the same way, the order of a map is random
+		sort.Strings(pairs)
+
+		out.WriteString("{")
+		out.WriteString(strings.Join(pairs, ", "))
+		out.WriteString("}")
+	case *Cell:
+		return inspect(out, obj.Value, limit)
+	default:
+		out.WriteString(obj.Inspect())
+	}
+
+	return out.Len() <= limit
 }
 
 // An unevaluated AST node
@@ -252,3 +449,47 @@ func (m *Macro) Inspect() string {
 
 	return out.String()
 }
+
+// A function that has been compiled into bytecode
+type CompiledFunction struct {
+	Instructions  code.Instructions // The instructions that make up the body of the function
+	NumLocals     int               // The number of local bindings, including the parameters, the function creates
+	NumParameters int               // The number of parameters the function expects
+	SourceMap     code.SourceMap    // The positions in the source code of the instructions
+	Locals        []string          // The names of the local bindings by index, the parameters come first
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// A compiled function along with the free variables it captured when it was created, it is the virtual machine's
+// representation of a function so it has the same type as one
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	params := make([]string, c.Fn.NumParameters)
+	for i := range params {
+		// The names are unknown if the function was not compiled from source code
+		params[i] = "_"
+		if i < len(c.Fn.Locals) {
+			params[i] = c.Fn.Locals[i]
+		}
+	}
+
+	return inspectFunction(params)
+}
+
+// A binding shared between a function and the closures that assign to it, the cell itself is never visible to a program
+type Cell struct {
+	Value Object // The value of the binding, nil until the let statement defining it has run
+	Name  string // The name of the binding, which is reported if it is read before it has a value
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
@@ -1,6 +1,14 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/grantwforsythe/monkeylang/pkg/ast"
+	"github.com/grantwforsythe/monkeylang/pkg/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello"}
@@ -21,6 +29,105 @@ func TestStringHashKey(t *testing.T) {
 	}
 }
 
+func TestStringRuneCount(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int
+	}{
+		{"", 0},
+		{"monkey", 6},
+		{"héllo", 5},
+		{"世界", 2},
+		{"🐒", 1},
+	}
+
+	for _, tt := range tests {
+		str := &String{Value: tt.value}
+		if got := str.RuneCount(); got != tt.expected {
+			t.Errorf("RuneCount() of %q = %d, expected %d", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if got := f.Inspect(); got != tt.expected {
+			t.Errorf("Inspect() = %q, expected %q", got, tt.expected)
+		}
+	}
+}
+
+func TestFunctionInspect(t *testing.T) {
+	params := []*ast.Identifier{{Value: "x"}, {Value: "y"}}
+
+	// The engines show a function the same way, the parameters of a compiled function are the first of its locals
+	tests := []struct {
+		fn       Object
+		expected string
+	}{
+		{&Function{Parameters: params, Body: &ast.BlockStatement{}}, "fn(x, y)"},
+		{&Function{Body: &ast.BlockStatement{}}, "fn()"},
+		{&Closure{Fn: &CompiledFunction{NumLocals: 3, NumParameters: 2, Locals: []string{"x", "y", "z"}}}, "fn(x, y)"},
+		{&Closure{Fn: &CompiledFunction{NumLocals: 2, NumParameters: 2}}, "fn(_, _)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.fn.Inspect(); got != tt.expected {
+			t.Errorf("Inspect() = %q, expected %q", got, tt.expected)
+		}
+
+		if tt.fn.Type() != FUNCTION_OBJ {
+			t.Errorf("Type() = %q, expected %q", tt.fn.Type(), FUNCTION_OBJ)
+		}
+	}
+}
+
+func TestInspectLimited(t *testing.T) {
+	// The array contains itself 2^40 times over, building its whole representation would never finish
+	nested := &Array{Elements: []Object{&Integer{Value: 1}}}
+	for i := 0; i < 40; i++ {
+		nested = &Array{Elements: []Object{nested, nested}}
+	}
+
+	one := &String{Value: "one"}
+	hash := &Hash{Pairs: map[HashKey]HashPair{
+		one.HashKey(): {Key: one, Value: &Array{Elements: []Object{&Integer{Value: 1}}}},
+	}}
+
+	tests := []struct {
+		obj      Object
+		limit    int
+		expected string
+		ok       bool
+	}{
+		{&String{Value: "abc"}, 3, "abc", true},
+		{&String{Value: "abc"}, 2, "", false},
+		{hash, 10, "{one: [1]}", true},
+		{hash, 9, "", false},
+		{nested, 1 << 20, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := InspectLimited(tt.obj, tt.limit)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("InspectLimited(%d) = (%q, %t), expected (%q, %t)", tt.limit, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
 func TestBooleanHashKey(t *testing.T) {
 	hello1 := &Boolean{Value: true}
 	hello2 := &Boolean{Value: true}
@@ -58,3 +165,267 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("strings with same content have different hash keys")
 	}
 }
+
+func TestBigIntegerHashKey(t *testing.T) {
+	big1 := NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 64))
+	big2 := NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 64))
+	diff := NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 65))
+
+	if big1.HashKey() != big2.HashKey() {
+		t.Errorf("big integers with same content have different hash keys")
+	}
+
+	if big1.HashKey() == diff.HashKey() {
+		t.Errorf("big integers with different content have same hash keys")
+	}
+
+	if big1.Inspect() != "18446744073709551616" {
+		t.Errorf("Inspect() = %q, expected %q", big1.Inspect(), "18446744073709551616")
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	maxInt := &Integer{Value: math.MaxInt64}
+	minInt := &Integer{Value: math.MinInt64}
+	one := &Integer{Value: 1}
+	minusOne := &Integer{Value: -1}
+
+	// The products in these tests are small enough that Mul always succeeds
+	mul := func(a, b *Integer) *Integer {
+		product, _ := a.Mul(b)
+		return product
+	}
+
+	tests := []struct {
+		name     string
+		result   *Integer
+		expected string
+		big      bool
+	}{
+		{"add", (&Integer{Value: 2}).Add(one), "3", false},
+		{"add overflow", maxInt.Add(one), "9223372036854775808", true},
+		{"add negative overflow", minInt.Add(minusOne), "-9223372036854775809", true},
+		{"add demotes", maxInt.Add(one).Add(minusOne), "9223372036854775807", false},
+		{"sub overflow", minInt.Sub(one), "-9223372036854775809", true},
+		{"sub positive overflow", maxInt.Sub(minusOne), "9223372036854775808", true},
+		{"sub demotes", minInt.Sub(one).Sub(minusOne), "-9223372036854775808", false},
+		{"mul", mul(&Integer{Value: -3}, &Integer{Value: 4}), "-12", false},
+		{"mul overflow", mul(maxInt, &Integer{Value: 2}), "18446744073709551614", true},
+		{"mul min by minus one", mul(minInt, minusOne), "9223372036854775808", true},
+		{"minus one by mul min", mul(minusOne, minInt), "9223372036854775808", true},
+		{"quo", (&Integer{Value: -7}).Quo(&Integer{Value: 2}), "-3", false},
+		{"quo min by minus one", minInt.Quo(minusOne), "9223372036854775808", true},
+		{"quo demotes", maxInt.Add(one).Quo(&Integer{Value: 2}), "4611686018427387904", false},
+		{"rem", (&Integer{Value: -7}).Rem(&Integer{Value: 3}), "-1", false},
+		{"rem big", maxInt.Add(one).Rem(&Integer{Value: 10}), "8", false},
+		{"neg", one.Neg(), "-1", false},
+		{"neg min", minInt.Neg(), "9223372036854775808", true},
+		{"neg demotes", minInt.Neg().Neg(), "-9223372036854775808", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.result.Inspect(); got != tt.expected {
+			t.Errorf("%s: Inspect() = %q, expected %q", tt.name, got, tt.expected)
+		}
+
+		if tt.result.IsBig() != tt.big {
+			t.Errorf("%s: IsBig() = %t, expected %t", tt.name, tt.result.IsBig(), tt.big)
+		}
+	}
+}
+
+func TestIntegerMulLimit(t *testing.T) {
+	half := NewBigInteger(new(big.Int).Lsh(big.NewInt(1), MaxIntegerBits/2-1))
+
+	if _, ok := half.Mul(half); !ok {
+		t.Errorf("multiplying two integers of %d bits failed", MaxIntegerBits/2)
+	}
+
+	if _, ok := half.Mul(half.Add(half)); ok {
+		t.Errorf("multiplying integers with more than %d bits together succeeded", MaxIntegerBits)
+	}
+}
+
+func TestIntegerPow(t *testing.T) {
+	tests := []struct {
+		base     int64
+		exponent *Integer
+		expected string
+		ok       bool
+	}{
+		{2, &Integer{Value: 10}, "1024", true},
+		{-3, &Integer{Value: 3}, "-27", true},
+		{2, &Integer{Value: 64}, "18446744073709551616", true},
+		{7, &Integer{Value: 0}, "1", true},
+		{1, NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 64)), "1", true},
+		{-1, NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 64)), "1", true},
+		{-1, &Integer{Value: 99999999999}, "-1", true},
+		{0, &Integer{Value: 99999999999}, "0", true},
+		{2, &Integer{Value: 99999999999}, "", false},
+		{2, NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 64)), "", false},
+	}
+
+	for _, tt := range tests {
+		result, ok := (&Integer{Value: tt.base}).Pow(tt.exponent)
+		if ok != tt.ok {
+			t.Errorf("%d ** %s: ok = %t, expected %t", tt.base, tt.exponent.Inspect(), ok, tt.ok)
+			continue
+		}
+
+		if ok && result.Inspect() != tt.expected {
+			t.Errorf("%d ** %s = %s, expected %s", tt.base, tt.exponent.Inspect(), result.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestIsTruthy(t *testing.T) {
+	tests := []struct {
+		obj      Object
+		expected bool
+	}{
+		{&Null{}, false},
+		{&Boolean{Value: false}, false},
+		{&Boolean{Value: true}, true},
+		{&Integer{Value: 0}, false},
+		{&Integer{Value: 1}, true},
+		{&Integer{Value: -1}, true},
+		{NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 64)), true},
+		{&Float{Value: 0}, false},
+		{&Float{Value: 0.5}, true},
+		{&String{Value: ""}, false},
+		{&String{Value: "a"}, true},
+		{&Array{}, false},
+		{&Array{Elements: []Object{&Null{}}}, true},
+		{&Hash{Pairs: map[HashKey]HashPair{}}, false},
+		{&Hash{Pairs: map[HashKey]HashPair{{}: {}}}, true},
+		{&Builtin{}, true},
+	}
+
+	for _, tt := range tests {
+		if got := IsTruthy(tt.obj); got != tt.expected {
+			t.Errorf("IsTruthy(%s) = %t, expected %t", tt.obj.Type(), got, tt.expected)
+		}
+	}
+}
+
+func TestGetBuiltinByName(t *testing.T) {
+	for _, def := range Builtins {
+		if GetBuiltinByName(def.Name) != def.Builtin {
+			t.Errorf("builtin %q was not found in the registry", def.Name)
+		}
+	}
+
+	if GetBuiltinByName("foobar") != nil {
+		t.Errorf("expected no builtin for %q", "foobar")
+	}
+}
+
+func TestPutsAndQuit(t *testing.T) {
+	exit, stdout := Exit, Stdout
+	t.Cleanup(func() { Exit, Stdout = exit, stdout })
+
+	var out strings.Builder
+	code := -1
+	Stdout = &out
+	Exit = func(c int) { code = c }
+
+	GetBuiltinByName("puts").Fn(&Integer{Value: 1}, &String{Value: "a"})
+	if out.String() != "1\na\n" {
+		t.Errorf("puts wrote %q, want=%q", out.String(), "1\na\n")
+	}
+
+	GetBuiltinByName("quit").Fn()
+	if code != 0 {
+		t.Errorf("quit exited with %d, want=0", code)
+	}
+}
+
+func TestErrorInspect(t *testing.T) {
+	err := &Error{
+		Message: "type mismatch: INTEGER + BOOLEAN",
+		Pos:     token.Position{Line: 1, Column: 22},
+		Stack: []StackFrame{
+			{Function: "add", Pos: token.Position{Line: 2, Column: 4}},
+			{Function: "<anonymous>"},
+		},
+	}
+
+	expected := "Error: 1:22: type mismatch: INTEGER + BOOLEAN\n\tat add (2:4)\n\tat <anonymous>"
+	if err.Inspect() != expected {
+		t.Errorf("wrong inspect. expected=%q, got=%q", expected, err.Inspect())
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	recursive := StackFrame{Function: "f", Pos: token.Position{Line: 1, Column: 43}}
+	outer := StackFrame{Function: "f", Pos: token.Position{Line: 1, Column: 55}}
+
+	g := StackFrame{Function: "g", Pos: token.Position{Line: 1, Column: 18}}
+	h := StackFrame{Function: "h", Pos: token.Position{Line: 1, Column: 42}}
+
+	tests := []struct {
+		stack    []StackFrame
+		expected []string
+	}{
+		{nil, []string{}},
+		{
+			append(repeatFrames([]StackFrame{recursive}, 1023), outer),
+			[]string{"at f (1:43)", "... 1022 more", "at f (1:55)"},
+		},
+		{
+			repeatFrames([]StackFrame{g, h}, 15),
+			[]string{
+				"at g (1:18)", "at h (1:42)", "at g (1:18)", "at h (1:42)", "at g (1:18)",
+				"at h (1:42)", "at g (1:18)", "at h (1:42)", "at g (1:18)", "at h (1:42)",
+				"at g (1:18)", "at h (1:42)", "at g (1:18)", "at h (1:42)", "at g (1:18)",
+				"at h (1:42)", "at g (1:18)", "at h (1:42)", "at g (1:18)", "at h (1:42)",
+				"... 10 more",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		err := &Error{Message: "stack overflow", Stack: tt.stack}
+
+		lines := err.StackTrace()
+		if strings.Join(lines, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong stack trace. expected=%q, got=%q", tt.expected, lines)
+		}
+	}
+}
+
+func repeatFrames(frames []StackFrame, n int) []StackFrame {
+	repeated := []StackFrame{}
+	for i := 0; i < n; i++ {
+		repeated = append(repeated, frames...)
+	}
+	return repeated
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("counter", &Integer{Value: 1})
+
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("shadowed", &Integer{Value: 1})
+
+	if _, ok := inner.Assign("counter", &Integer{Value: 2}); !ok {
+		t.Fatalf("expected counter to be assigned")
+	}
+
+	if value, _ := outer.Get("counter"); value.(*Integer).Value != 2 {
+		t.Errorf("counter was not assigned in the outer environment. got=%d", value.(*Integer).Value)
+	}
+
+	if _, ok := inner.store["counter"]; ok {
+		t.Errorf("counter was defined in the inner environment")
+	}
+
+	if _, ok := inner.Assign("missing", &Integer{Value: 1}); ok {
+		t.Errorf("expected assignment to an undefined identifier to fail")
+	}
+
+	if _, ok := outer.Get("missing"); ok {
+		t.Errorf("missing was defined by a failed assignment")
+	}
+}
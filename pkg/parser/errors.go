@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grantwforsythe/monkeylang/pkg/token"
+)
+
+// ErrorCode identifies the kind of a parser error so that tooling can react to it without matching on messages.
+type ErrorCode string
+
+const (
+	ErrUnexpectedToken   ErrorCode = "unexpected-token"   // ErrUnexpectedToken is used when the next token is not the one the grammar requires.
+	ErrNoPrefixParseFn   ErrorCode = "no-prefix-parse-fn" // ErrNoPrefixParseFn is used when a token cannot start an expression.
+	ErrInvalidInteger    ErrorCode = "invalid-integer"    // ErrInvalidInteger is used when an integer literal is malformed, e.g. 0b102 or 1__000.
+	ErrInvalidFloat      ErrorCode = "invalid-float"      // ErrInvalidFloat is used when a floating point literal is malformed, e.g. 1_.5.
+	ErrNumberOverflow    ErrorCode = "number-overflow"    // ErrNumberOverflow is used when a float literal is too large to be represented.
+	ErrOutsideLoop       ErrorCode = "outside-loop"       // ErrOutsideLoop is used when a break or continue is not inside of a loop.
+	ErrInvalidAssignment ErrorCode = "invalid-assignment" // ErrInvalidAssignment is used when the target of an assignment is not an identifier or index expression.
+	ErrInvalidToken      ErrorCode = "invalid-token"      // ErrInvalidToken is used when the lexer could not tokenize the input, e.g. a string that is never closed.
+)
+
+// Error represents a problem found while parsing a program.
+type Error struct {
+	Code     ErrorCode       // Code represents the kind of error.
+	Pos      token.Position  // Pos represents the position of the offending token.
+	Expected token.TokenType // Expected represents the token the parser required, it is empty if any token would not have helped.
+	Actual   token.Token     // Actual represents the token that was found instead.
+	Message  string          // Message represents a human readable description of the error.
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// Render formats the error along with an excerpt of the line it occurred on and a caret pointing at the offending token.
+// The source is expected to be the input the parser was created with.
+func (e *Error) Render(source string) string {
+	lines := strings.Split(source, "\n")
+	if !e.Pos.IsValid() || e.Pos.Line > len(lines) {
+		return e.Error()
+	}
+
+	line := strings.TrimRight(lines[e.Pos.Line-1], "\r")
+	gutter := fmt.Sprintf("%d", e.Pos.Line)
+
+	// Keep tabs in the padding so the caret lines up with the excerpt however wide the tabs are rendered.
+	// Columns are counted in characters so the padding has one space per character rather than per byte.
+	var padding strings.Builder
+	for i, ch := range []rune(line) {
+		if i >= e.Pos.Column-1 {
+			break
+		}
+
+		if ch == '\t' {
+			padding.WriteByte('\t')
+		} else {
+			padding.WriteByte(' ')
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString(e.Error() + "\n")
+	out.WriteString(fmt.Sprintf(" %s | %s\n", gutter, line))
+	out.WriteString(fmt.Sprintf(" %s | %s^", strings.Repeat(" ", len(gutter)), padding.String()))
+
+	return out.String()
+}
@@ -2,7 +2,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 
 	"github.com/grantwforsythe/monkeylang/pkg/ast"
@@ -16,38 +18,42 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	LOGICALOR   // ||
+	LOGICALAND  // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	POWER       // **
 	CALL        // foobar(baz)
 	INDEX       // array[index]
 )
 
 // Map of tokens to their respective precedence, i.e. BEDMAS
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
-}
-
-// TODO: Replace errorString with general errors.New(). A custom error type would only be needed when we want to wrap errors.
-// fmt.Errorf with the %w verb adds the Unwrap method to the newly created error
-
-type errorString struct {
-	s string
-}
-
-func (e *errorString) Error() string {
-	return e.s
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              LOGICALOR,
+	token.AND:             LOGICALAND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.ASTERISK:        PRODUCT,
+	token.SLASH:           PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.POWER:           POWER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type (
@@ -61,12 +67,17 @@ type Parser struct {
 	peekToken      token.Token                       // The next current token
 	prefixParseFns map[token.TokenType]prefixParseFn // Map of tokens to prefix functions
 	infixParseFns  map[token.TokenType]infixParseFn  // Map of tokens to infix functions
-	errors         []errorString                     // Slice of all parser errors
+	errors         []*Error                          // Slice of all parser errors
+	panicking      bool                              // Whether errors are being suppressed until the end of the broken statement
+	loopDepth      int                               // The number of loops enclosing the current token within the current function
+	lexerErrors    int                               // The number of errors reported by the lexer that have been recorded
+	peekErrors     []*lexer.Error                    // The errors the lexer found in the peek token, reported once it is the current token
+	braces         int                               // The number of { before the current token which have not been closed
 }
 
 // Create a new parser from a lexer registering all of the prefix and infix functions.
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []errorString{}}
+	p := &Parser{l: l, errors: []*Error{}}
 
 	// Read the first two tokens so both curr and peek are set
 	p.nextToken()
@@ -75,7 +86,9 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.STRING_HEAD, p.parseInterpolatedString)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBooleanExpression)
@@ -97,8 +110,19 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	return p
 }
@@ -108,10 +132,13 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.currToken.Type != token.EOF {
+		errs, braces := len(p.errors), p.braces
 		// nolint:staticcheck
 		stmt := p.parseStatement()
-		// nolint:staticcheck
-		if stmt != nil {
+		// The parser is already panicking if the first token of the statement is invalid
+		if len(p.errors) > errs || p.panicking {
+			p.synchronize(braces)
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
@@ -120,10 +147,64 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
-func (p *Parser) Errors() []errorString {
+// Errors gets the errors found while parsing, in the order they were found.
+func (p *Parser) Errors() []*Error {
 	return p.errors
 }
 
+// addError records an error unless the parser is already recovering from an earlier error in the same statement,
+// in which case it is most likely a consequence of the first one.
+func (p *Parser) addError(err *Error) {
+	if p.panicking {
+		return
+	}
+
+	p.panicking = true
+	p.errors = append(p.errors, err)
+}
+
+// synchronize discards the tokens of a statement that failed to parse, stopping at the end of the statement (;), after a
+// block (}) opened by the statement, or before the end of the block the statement is in. This keeps a single mistake from
+// cascading into errors for every token that follows it. The statement started with the given number of unclosed braces.
+func (p *Parser) synchronize(braces int) {
+	defer func() { p.panicking = false }()
+
+	for {
+		// The blocks opened by the statement which are still open, the error may have occurred inside one, e.g. { x }
+		depth := p.braces - braces
+		switch p.currToken.Type {
+		case token.EOF:
+			return
+		case token.SEMICOLON:
+			if depth == 0 {
+				return
+			}
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				return
+			}
+
+			depth--
+			if depth == 0 {
+				// Skip optional semicolon
+				if p.peekToken.Type == token.SEMICOLON {
+					p.nextToken()
+				}
+				return
+			}
+		}
+
+		// The closing brace is left for the enclosing block to consume.
+		if depth == 0 && (p.peekToken.Type == token.RBRACE || p.peekToken.Type == token.EOF) {
+			return
+		}
+
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.currToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
@@ -143,11 +224,21 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseFunctionBody()
 
 	return lit
 }
 
+// parseFunctionBody parses the body of a function or macro.
+// Loops do not extend into the body so a break or continue cannot jump out of the function.
+func (p *Parser) parseFunctionBody() *ast.BlockStatement {
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = loopDepth }()
+
+	return p.parseBlockStatement()
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	params := []*ast.Identifier{}
 
@@ -217,10 +308,13 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	for p.currToken.Type != token.RBRACE && p.currToken.Type != token.EOF {
+		errs, braces := len(p.errors), p.braces
 		// nolint:staticcheck
 		stmt := p.parseStatement()
-		// nolint:staticcheck
-		if stmt != nil {
+		// The parser is already panicking if the first token of the statement is invalid
+		if len(p.errors) > errs || p.panicking {
+			p.synchronize(braces)
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
@@ -249,6 +343,31 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
 }
 
+// Parse a string with embedded expressions, e.g. "Hello, ${name}!", which the lexer splits into a STRING_HEAD, the tokens
+// of each expression separated by STRING_MIDDLE tokens, and a STRING_TAIL.
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.currToken}
+	str.Parts = []ast.Expression{&ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}}
+
+	for {
+		p.nextToken()
+		str.Parts = append(str.Parts, p.parseExpression(LOWEST))
+
+		switch p.peekToken.Type {
+		case token.STRING_MIDDLE:
+			p.nextToken()
+			str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal})
+		case token.STRING_TAIL:
+			p.nextToken()
+			str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal})
+			return str
+		default:
+			p.peekError(token.STRING_TAIL)
+			return nil
+		}
+	}
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.currToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
@@ -261,6 +380,9 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	p.nextToken()
 
 	expression.Right = p.parseExpression(PREFIX)
+	if expression.Right == nil {
+		return nil
+	}
 
 	return expression
 }
@@ -273,8 +395,16 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	exp := &ast.InfixExpression{Token: p.currToken, Operator: p.currToken.Literal, Left: left}
 
 	precedence := p.curPrecedence()
+	// Exponents are right associative, i.e. 2 ** 3 ** 2 is 2 ** (3 ** 2)
+	if p.currToken.Type == token.POWER {
+		precedence--
+	}
+
 	p.nextToken()
 	exp.Right = p.parseExpression(precedence)
+	if exp.Right == nil {
+		return nil
+	}
 
 	return exp
 }
@@ -292,13 +422,47 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// Parse an assignment to an identifier or an index expression.
+// Assignments are right associative so that a = b = c assigns c to both a and b.
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{Token: p.currToken, Operator: p.currToken.Literal, Target: target}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexEpression:
+	case nil:
+		return nil
+	default:
+		// The target is missing some of its children if an error was already found in it so it cannot be printed
+		if p.panicking {
+			return nil
+		}
+
+		p.addError(&Error{
+			Code:    ErrInvalidAssignment,
+			Pos:     p.currToken.Pos,
+			Actual:  p.currToken,
+			Message: fmt.Sprintf("cannot assign to %s", target.String()),
+		})
+		return nil
+	}
+
+	p.nextToken()
+	exp.Value = p.parseExpression(ASSIGN - 1)
+
+	return exp
+}
+
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := errorString{s: fmt.Sprintf("no prefix parse function for %s", t)}
-	p.errors = append(p.errors, msg)
+	p.addError(&Error{
+		Code:    ErrNoPrefixParseFn,
+		Pos:     p.currToken.Pos,
+		Actual:  p.currToken,
+		Message: fmt.Sprintf("no prefix parse function for %s", t),
+	})
 }
 
 func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
@@ -306,13 +470,53 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := errorString{s: fmt.Sprintf("expected next token to be %s. got=%s", t, p.peekToken.Type)}
-	p.errors = append(p.errors, msg)
+	// An invalid token is the cause of the error, e.g. an unterminated string where a ) was expected
+	if len(p.peekErrors) > 0 {
+		p.reportLexerErrors(p.peekToken)
+		return
+	}
+
+	p.addError(&Error{
+		Code:     ErrUnexpectedToken,
+		Pos:      p.peekToken.Pos,
+		Expected: t,
+		Actual:   p.peekToken,
+		Message:  fmt.Sprintf("expected next token to be %s. got=%s", t, p.peekToken.Type),
+	})
 }
 
 func (p *Parser) nextToken() {
+	switch p.currToken.Type {
+	case token.LBRACE:
+		p.braces++
+	case token.RBRACE:
+		p.braces--
+	}
 	p.currToken = p.peekToken
+
+	// Errors from the lexer are reported once the parser reaches the invalid token rather than while the statement before
+	// it peeks at it, so that the statement containing the token is the one discarded.
+	p.reportLexerErrors(p.currToken)
+
 	p.peekToken = p.l.NextToken()
+	p.peekErrors = p.l.Errors()[p.lexerErrors:]
+	p.lexerErrors = len(p.l.Errors())
+}
+
+// reportLexerErrors records the errors the lexer found in the peek token, which is given as the token they were found in.
+// They are always recorded since they are not a consequence of an earlier error, but the errors the invalid token
+// causes in the rest of the statement are, e.g. a missing ) after an unterminated string.
+func (p *Parser) reportLexerErrors(tok token.Token) {
+	for _, err := range p.peekErrors {
+		p.errors = append(p.errors, &Error{
+			Code:    ErrInvalidToken,
+			Pos:     err.Pos,
+			Actual:  tok,
+			Message: err.Message,
+		})
+		p.panicking = true
+	}
+	p.peekErrors = nil
 }
 
 // Check if the next token is equal to the expected token.
@@ -344,6 +548,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	// Let the function know what it is bound to so that it can refer to itself
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	// Skip optional semicolon
 	if p.peekToken.Type == token.SEMICOLON {
 		p.nextToken()
@@ -370,10 +579,49 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	stmt := &ast.IntegerLiteral{Token: p.currToken}
 
+	// A base of 0 accepts the prefixes for hexadecimal, octal and binary as well as underscores between digits
 	value, err := strconv.ParseInt(p.currToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		// The literal is too large for 64 bits so it is kept as a big integer instead
+		if n, ok := new(big.Int).SetString(p.currToken.Literal, 0); ok {
+			stmt.Big = n
+			return stmt
+		}
+	}
 	if err != nil {
-		msg := errorString{s: fmt.Sprintf("could not parse %s as integer", p.currToken.Literal)}
-		p.errors = append(p.errors, msg)
+		p.addError(&Error{
+			Code:    ErrInvalidInteger,
+			Pos:     p.currToken.Pos,
+			Actual:  p.currToken,
+			Message: fmt.Sprintf("could not parse %s as integer", p.currToken.Literal),
+		})
+		return nil
+	}
+
+	stmt.Value = value
+
+	return stmt
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	stmt := &ast.FloatLiteral{Token: p.currToken}
+
+	value, err := strconv.ParseFloat(p.currToken.Literal, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		p.addError(&Error{
+			Code:    ErrNumberOverflow,
+			Pos:     p.currToken.Pos,
+			Actual:  p.currToken,
+			Message: fmt.Sprintf("float %s overflows a 64-bit float", p.currToken.Literal),
+		})
+		return nil
+	} else if err != nil {
+		p.addError(&Error{
+			Code:    ErrInvalidFloat,
+			Pos:     p.currToken.Pos,
+			Actual:  p.currToken,
+			Message: fmt.Sprintf("could not parse %s as float", p.currToken.Literal),
+		})
 		return nil
 	}
 
@@ -422,11 +670,146 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.currToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	// Skip optional semicolon
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// Parse a loop of the form for (init; condition; post) { body } where each part of the header is optional.
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.currToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	if p.currToken.Type != token.SEMICOLON {
+		stmt.Init = p.parseStatement()
+
+		// Statements skip over an optional semicolon so it is only missing if the current token is not one
+		if p.currToken.Type != token.SEMICOLON && !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	if p.currToken.Type != token.SEMICOLON {
+		stmt.Condition = p.parseExpression(LOWEST)
+
+		if !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	if p.currToken.Type != token.RPAREN {
+		stmt.Post = p.parseStatement()
+
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	// Skip optional semicolon
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseLoopBody parses the body of a loop in which break and continue are allowed.
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.currToken}
+
+	if p.loopDepth == 0 {
+		p.outsideLoopError()
+		return nil
+	}
+
+	// Skip optional semicolon
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.currToken}
+
+	if p.loopDepth == 0 {
+		p.outsideLoopError()
+		return nil
+	}
+
+	// Skip optional semicolon
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) outsideLoopError() {
+	p.addError(&Error{
+		Code:    ErrOutsideLoop,
+		Pos:     p.currToken.Pos,
+		Actual:  p.currToken,
+		Message: fmt.Sprintf("%s outside of loop", p.currToken.Literal),
+	})
+}
+
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok {
 		return p
@@ -515,7 +898,7 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseFunctionBody()
 
 	return lit
 }
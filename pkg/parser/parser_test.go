@@ -6,6 +6,7 @@ import (
 
 	"github.com/grantwforsythe/monkeylang/pkg/ast"
 	"github.com/grantwforsythe/monkeylang/pkg/lexer"
+	"github.com/grantwforsythe/monkeylang/pkg/token"
 )
 
 func TestParsingLetStatement(t *testing.T) {
@@ -121,6 +122,73 @@ func TestParsingIntegerLiteralExpression(t *testing.T) {
 	testIntegerLiteral(t, stmt.Expression, 5)
 }
 
+func TestParsingNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"0xFF", int64(255)},
+		{"0o17", int64(15)},
+		{"0b1010", int64(10)},
+		{"1_000_000", int64(1000000)},
+		{"9223372036854775807", int64(9223372036854775807)},
+		{"99999999999999999999", "99999999999999999999"},
+		{"0xFFFFFFFFFFFFFFFFFF", "4722366482869645213695"},
+		{"1_000_000_000_000_000_000_000", "1000000000000000000000"},
+		{"3.14", 3.14},
+		{"1e9", 1e9},
+		{"1E+9", 1e9},
+		{"2.5e-3", 0.0025},
+		{"1_000.5", 1000.5},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+
+		switch expected := tt.expected.(type) {
+		case int64:
+			literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+			if !ok {
+				t.Errorf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+				continue
+			}
+
+			if literal.Value != expected {
+				t.Errorf("literal.Value not %d. got=%d", expected, literal.Value)
+			}
+		case string:
+			literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+			if !ok {
+				t.Errorf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+				continue
+			}
+
+			if literal.Big == nil || literal.Big.String() != expected {
+				t.Errorf("literal.Big not %s. got=%v", expected, literal.Big)
+			}
+		case float64:
+			literal, ok := stmt.Expression.(*ast.FloatLiteral)
+			if !ok {
+				t.Errorf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+				continue
+			}
+
+			if literal.Value != expected {
+				t.Errorf("literal.Value not %g. got=%g", expected, literal.Value)
+			}
+
+			if literal.TokenLiteral() != tt.input {
+				t.Errorf("literal.TokenLiteral not %s. got=%s", tt.input, literal.TokenLiteral())
+			}
+		}
+	}
+}
+
 func TestParsingPrefixExpression(t *testing.T) {
 	prefixTest := []struct {
 		input    string
@@ -268,6 +336,54 @@ func TestParsingOperatorPrecedence(t *testing.T) {
 			"a + b + c;",
 			"((a + b) + c)",
 		},
+		{
+			"a = b = c + d;",
+			"(a = (b = (c + d)))",
+		},
+		{
+			"a[i] += b * c;",
+			"((a[i]) += (b * c))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a + b % c",
+			"(a + (b % c))",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2 ** 2))",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == b && c < d",
+			"((a == b) && (c < d))",
+		},
+		{
+			"x = a || b",
+			"(x = (a || b))",
+		},
+		{
+			"x -= 1 == y;",
+			"(x -= (1 == y))",
+		},
 		{
 			"a + b - c;",
 			"((a + b) - c)",
@@ -571,6 +687,32 @@ func TestParsingFunctionLiteral(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "+", "x", "y")
 }
 
+func TestParsingFunctionLiteralWithName(t *testing.T) {
+	input := "let myFunction = fn() { };"
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not contain 1 statement. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not of type *ast.LetStatement. got=%T", program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not of type *ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Errorf("function literal name wrong. expected=%q, got=%q", "myFunction", function.Name)
+	}
+}
+
 func TestParsingCallExpression(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -684,6 +826,41 @@ func TestParsingStringLiteral(t *testing.T) {
 	}
 }
 
+func TestParsingInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		parts    int
+	}{
+		{`"${x}"`, `"${x}"`, 3},
+		{`"1 + 2 = ${1 + 2}!"`, `"1 + 2 = ${(1 + 2)}!"`, 3},
+		{`"${a}, ${b[0]} and ${f("c")}"`, `"${a}, ${(b[0])} and ${f(c)}"`, 7},
+		{`"outer ${"inner ${x}"}"`, `"outer ${"inner ${x}"}"`, 3},
+		{`"${ {"a": 1}["a"] }"`, `"${({a:1}[a])}"`, 3},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+
+		if len(str.Parts) != tt.parts {
+			t.Errorf("wrong number of parts for %q. expected=%d, got=%d", tt.input, tt.parts, len(str.Parts))
+		}
+
+		if str.String() != tt.expected {
+			t.Errorf("wrong interpolated string. expected=%q, got=%q", tt.expected, str.String())
+		}
+	}
+}
+
 func TestParsingArrayLiteral(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -1096,3 +1273,297 @@ func testInfixExpression(t *testing.T, exp ast.Expression, operator string, left
 
 	return true
 }
+
+func TestParsingWhileStatement(t *testing.T) {
+	input := "while (x < y) { x; break; continue; }"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not have enough statements. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not of type ast.WhileStatement. got=%T", program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "<", "x", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("stmt.Body.Statements does not have 3 statements. got=%d", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("stmt.Body.Statements[1] is not of type ast.BreakStatement. got=%T", stmt.Body.Statements[1])
+	}
+
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("stmt.Body.Statements[2] is not of type ast.ContinueStatement. got=%T", stmt.Body.Statements[2])
+	}
+}
+
+func TestParsingForStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for (let i = 0; i < 10; let i = i + 1) { i }", "for (let i = 0; (i < 10); let i = (i + 1)) i"},
+		{"for (;;) { break; }", "for (; ; ) break;"},
+		{"for (let i = 0; ; ) { }", "for (let i = 0; ; ) "},
+		{"for (; x; x) { fn() { 1 } }", "for (; x; x) fn() 1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program does not have 1 statement. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not of type ast.ForStatement. got=%T", program.Statements[0])
+		}
+
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong for statement. expected=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []Error
+	}{
+		{
+			"let = 5;",
+			[]Error{
+				{Code: ErrUnexpectedToken, Expected: token.IDENT, Actual: token.Token{Type: token.ASSIGN, Literal: "="}},
+			},
+		},
+		{
+			"let x = ;",
+			[]Error{
+				{Code: ErrNoPrefixParseFn, Actual: token.Token{Type: token.SEMICOLON, Literal: ";"}},
+			},
+		},
+		{
+			"0b102;",
+			[]Error{
+				{Code: ErrInvalidInteger, Actual: token.Token{Type: token.INT, Literal: "0b102"}},
+			},
+		},
+		{
+			"1__000;",
+			[]Error{
+				{Code: ErrInvalidInteger, Actual: token.Token{Type: token.INT, Literal: "1__000"}},
+			},
+		},
+		{
+			"1_.5;",
+			[]Error{
+				{Code: ErrInvalidFloat, Actual: token.Token{Type: token.FLOAT, Literal: "1_.5"}},
+			},
+		},
+		{
+			"1.5_;",
+			[]Error{
+				{Code: ErrInvalidFloat, Actual: token.Token{Type: token.FLOAT, Literal: "1.5_"}},
+			},
+		},
+		{
+			"1e400;",
+			[]Error{
+				{Code: ErrNumberOverflow, Actual: token.Token{Type: token.FLOAT, Literal: "1e400"}},
+			},
+		},
+		// Parsing resumes after the end of the statement so each mistake is only reported once.
+		{
+			"let x 5 + 5 * (2; let y = 1; let = 2;",
+			[]Error{
+				{Code: ErrUnexpectedToken, Expected: token.ASSIGN, Actual: token.Token{Type: token.INT, Literal: "5"}},
+				{Code: ErrUnexpectedToken, Expected: token.IDENT, Actual: token.Token{Type: token.ASSIGN, Literal: "="}},
+			},
+		},
+		{
+			"a + b = c;",
+			[]Error{
+				{Code: ErrInvalidAssignment, Actual: token.Token{Type: token.ASSIGN, Literal: "="}},
+			},
+		},
+		{
+			"break;",
+			[]Error{
+				{Code: ErrOutsideLoop, Actual: token.Token{Type: token.BREAK, Literal: "break"}},
+			},
+		},
+		// A function does not inherit the loop it is defined in.
+		{
+			"while (true) { fn() { continue; } }",
+			[]Error{
+				{Code: ErrOutsideLoop, Actual: token.Token{Type: token.CONTINUE, Literal: "continue"}},
+			},
+		},
+		{
+			"for (let i = 0; i < 10; let i = i + 1 { 1; 2; }; 3;",
+			[]Error{
+				{Code: ErrUnexpectedToken, Expected: token.RPAREN, Actual: token.Token{Type: token.LBRACE, Literal: "{"}},
+			},
+		},
+		{
+			`let x = "abc`,
+			[]Error{
+				{Code: ErrInvalidToken, Actual: token.Token{Type: token.STRING, Literal: "abc"}},
+			},
+		},
+		{
+			`"a ${1 + 2"`,
+			[]Error{
+				{Code: ErrInvalidToken, Actual: token.Token{Type: token.STRING, Literal: ""}},
+			},
+		},
+		// The } closes the hash literal the error occurred in rather than starting a statement of its own
+		{
+			"let x = 5; { x }",
+			[]Error{
+				{Code: ErrUnexpectedToken, Expected: token.COLON, Actual: token.Token{Type: token.RBRACE, Literal: "}"}},
+			},
+		},
+		{
+			"fn() { { x } }; let = 1",
+			[]Error{
+				{Code: ErrUnexpectedToken, Expected: token.COLON, Actual: token.Token{Type: token.RBRACE, Literal: "}"}},
+				{Code: ErrUnexpectedToken, Expected: token.IDENT, Actual: token.Token{Type: token.ASSIGN, Literal: "="}},
+			},
+		},
+		// The statement is discarded rather than left with an operand missing
+		{
+			"0!\xff",
+			[]Error{
+				{Code: ErrInvalidToken, Actual: token.Token{Type: token.ILLEGAL, Literal: "\uFFFD"}},
+			},
+		},
+		// The missing ) is a consequence of the unterminated string so it is not reported.
+		{
+			`puts("abc`,
+			[]Error{
+				{Code: ErrInvalidToken, Actual: token.Token{Type: token.STRING, Literal: "abc"}},
+			},
+		},
+		{
+			"fn() { let = 1; 2 } + (;",
+			[]Error{
+				{Code: ErrUnexpectedToken, Expected: token.IDENT, Actual: token.Token{Type: token.ASSIGN, Literal: "="}},
+				{Code: ErrNoPrefixParseFn, Actual: token.Token{Type: token.SEMICOLON, Literal: ";"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong number of errors for %q. expected=%d, got=%d (%v)", tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+
+		for i, expected := range tt.expected {
+			err := errors[i]
+
+			if err.Code != expected.Code {
+				t.Errorf("errors[%d] has wrong code. expected=%q, got=%q", i, expected.Code, err.Code)
+			}
+
+			if err.Expected != expected.Expected {
+				t.Errorf("errors[%d] has wrong expected token. expected=%q, got=%q", i, expected.Expected, err.Expected)
+			}
+
+			if err.Actual.Type != expected.Actual.Type || err.Actual.Literal != expected.Actual.Literal {
+				t.Errorf("errors[%d] has wrong actual token. expected=%+v, got=%+v", i, expected.Actual, err.Actual)
+			}
+
+			if err.Pos != err.Actual.Pos {
+				t.Errorf("errors[%d] is not positioned at the actual token. expected=%s, got=%s", i, err.Actual.Pos, err.Pos)
+			}
+		}
+	}
+}
+
+func TestErrorRender(t *testing.T) {
+	input := "let x = 1;\n\tlet y = ;"
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 1 {
+		t.Fatalf("expected 1 error. got=%d", len(p.Errors()))
+	}
+
+	expected := "2:10: no prefix parse function for ;\n" +
+		" 2 | \tlet y = ;\n" +
+		"   | \t        ^"
+
+	if rendered := p.Errors()[0].Render(input); rendered != expected {
+		t.Errorf("wrong rendered error. expected=%q, got=%q", expected, rendered)
+	}
+}
+
+func TestErrorRenderMultibyte(t *testing.T) {
+	input := `let café = "☕"; let = 1;`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 1 {
+		t.Fatalf("expected 1 error. got=%d", len(p.Errors()))
+	}
+
+	expected := "1:21: expected next token to be IDENT. got==\n" +
+		" 1 | let café = \"☕\"; let = 1;\n" +
+		"   |                     ^"
+
+	if rendered := p.Errors()[0].Render(input); rendered != expected {
+		t.Errorf("wrong rendered error. expected=%q, got=%q", expected, rendered)
+	}
+}
+
+func FuzzParseProgram(f *testing.F) {
+	f.Add("let add = fn(x, y) { x + y; }; add(1, 2);")
+	f.Add(`let h = {"a": [1, 2.5], true: "${x}"}; h["a"][0] += 1;`)
+	f.Add("for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } else { break; } }")
+	f.Add("let unless = macro(c, x) { quote(if (!(unquote(c))) { unquote(x) }) };")
+	f.Add("let x = ; if (x { 1 ")
+	f.Add("0!\xff")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+
+		for _, err := range p.Errors() {
+			if err.Code == "" {
+				t.Fatalf("error without a code: %s", err.Message)
+			}
+
+			// Rendering an error points at its position in the input, which has to exist
+			err.Render(input)
+		}
+
+		// Printing the program walks every node, which panics if the parser left an incomplete one behind
+		_ = program.String()
+	})
+}
@@ -5,21 +5,33 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/grantwforsythe/monkeylang/pkg/ast"
+	"github.com/grantwforsythe/monkeylang/pkg/compiler"
 	"github.com/grantwforsythe/monkeylang/pkg/evaluator"
 	"github.com/grantwforsythe/monkeylang/pkg/lexer"
 	"github.com/grantwforsythe/monkeylang/pkg/object"
 	"github.com/grantwforsythe/monkeylang/pkg/parser"
+	"github.com/grantwforsythe/monkeylang/pkg/runner"
+	"github.com/grantwforsythe/monkeylang/pkg/vm"
 )
 
 const PROMPT = ">> "
 
-// Start starts the REPL.
-func Start(in io.Reader, out io.Writer) {
+// Start starts the REPL using the given engine to execute each line.
+func Start(in io.Reader, out io.Writer, engine runner.Engine) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
 	macroEnv := object.NewEnvironment()
 
+	var run func(program ast.Node) bool
+	switch engine {
+	case runner.EngineVM:
+		run = newVMRunner(out)
+	default:
+		run = newEvalRunner(out)
+	}
+
 	for {
 		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
@@ -27,34 +39,134 @@ func Start(in io.Reader, out io.Writer) {
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		if !runLine(out, scanner.Text(), macroEnv, run) {
+			break
+		}
+	}
+}
 
-		program := p.ParseProgram()
+// runLine parses, expands and runs a single line of input.
+// A panic is recovered and reported as an error so that a bad line never ends the session.
+// Returns false if the output could not be written to.
+func runLine(out io.Writer, line string, macroEnv *object.Environment, run func(program ast.Node) bool) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = printErrors(out, "Internal", []string{fmt.Sprint(r)})
+		}
+	}()
 
-		if len(p.Errors()) != 0 {
-			for _, msg := range p.Errors() {
-				_, err := io.WriteString(
-					out,
-					"We ran into some monkey business! Parse errors:\n"+"\t- "+msg.Error()+"\n",
-				)
-				if err != nil {
-					break
-				}
-			}
-			continue
+	l := lexer.New(line)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		messages := []string{}
+		for _, err := range p.Errors() {
+			messages = append(messages, err.Render(line))
 		}
 
-		evaluator.DefineMacros(program, macroEnv)
-		expanded := evaluator.ExpandMacros(program, macroEnv)
+		return printErrors(out, "Parse", messages)
+	}
+
+	evaluator.DefineMacros(program, macroEnv)
+	expanded := evaluator.ExpandMacros(program, macroEnv)
+
+	return run(expanded)
+}
+
+// newEvalRunner creates a function which evaluates a program with the tree-walking evaluator.
+// The environment is shared between calls so bindings carry over from line to line.
+func newEvalRunner(out io.Writer) func(program ast.Node) bool {
+	env := object.NewEnvironment()
+
+	return func(program ast.Node) bool {
+		eval := evaluator.Eval(program, env)
 
-		eval := evaluator.Eval(expanded, env)
-		if eval != nil {
+		// Only expressions produce a value worth printing, e.g. a let statement evaluates to null.
+		if eval != nil && (producesValue(program) || eval.Type() == object.ERROR_OBJ) {
 			_, err := io.WriteString(out, eval.Inspect()+"\n")
 			if err != nil {
-				break
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// newVMRunner creates a function which compiles a program and executes it with the virtual machine.
+// The symbol table, constants and globals are shared between calls so bindings carry over from line to line.
+// The definitions of a line which fails to compile or run are discarded, since their globals may never have been set.
+func newVMRunner(out io.Writer) func(program ast.Node) bool {
+	symbolTable := compiler.NewSymbolTableWithBuiltins()
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+
+	return func(program ast.Node) bool {
+		previous := symbolTable.Copy()
+
+		comp := compiler.NewWithState(symbolTable, constants)
+		err := comp.Compile(program)
+		if err != nil {
+			symbolTable = previous
+			return printErrors(out, "Compilation", []string{err.Error()})
+		}
+
+		bytecode := comp.ByteCode()
+		constants = bytecode.Constants
+
+		machine := vm.NewWithGlobalsState(bytecode, globals)
+		err = machine.Run()
+		if err != nil {
+			symbolTable = previous
+			return printErrors(out, "Runtime", []string{err.Error()})
+		}
+
+		// Only expressions produce a value, e.g. a let statement does not.
+		if !producesValue(program) {
+			return true
+		}
+
+		result := machine.LastPoppedStackElem()
+		if result != nil {
+			_, err := io.WriteString(out, result.Inspect()+"\n")
+			if err != nil {
+				return false
 			}
 		}
+
+		return true
+	}
+}
+
+// producesValue checks if the last statement of a program leaves a value behind once it has been executed.
+func producesValue(node ast.Node) bool {
+	program, ok := node.(*ast.Program)
+	if !ok || len(program.Statements) == 0 {
+		return false
+	}
+
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *ast.ExpressionStatement, *ast.ReturnStatement:
+		return true
+	default:
+		return false
 	}
 }
+
+// printErrors writes a group of errors to the output.
+// Returns false if the output could not be written to.
+func printErrors(out io.Writer, kind string, messages []string) bool {
+	for _, msg := range messages {
+		_, err := io.WriteString(
+			out,
+			"We ran into some monkey business! "+kind+" errors:\n"+"\t- "+strings.ReplaceAll(msg, "\n", "\n\t  ")+"\n",
+		)
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
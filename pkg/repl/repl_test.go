@@ -0,0 +1,169 @@
+package repl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/grantwforsythe/monkeylang/pkg/runner"
+)
+
+func TestStart(t *testing.T) {
+	input := strings.Join([]string{
+		"let a = 5;",
+		"let double = fn(x) { x * 2 };",
+		"double(a)",
+		"a + true",
+		"let b = ;",
+	}, "\n")
+
+	tests := []struct {
+		engine   runner.Engine
+		expected []string
+	}{
+		{
+			runner.EngineEval,
+			[]string{
+				"10",
+				"Error: 1:3: type mismatch: INTEGER + BOOLEAN",
+				"We ran into some monkey business! Parse errors:\n\t- 1:9: no prefix parse function for ;\n\t   1 | let b = ;\n\t     |         ^",
+			},
+		},
+		{
+			runner.EngineVM,
+			[]string{
+				"10",
+				"We ran into some monkey business! Runtime errors:\n\t- 1:3: type mismatch: INTEGER + BOOLEAN",
+				"We ran into some monkey business! Parse errors:\n\t- 1:9: no prefix parse function for ;\n\t   1 | let b = ;\n\t     |         ^",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(input), &out, tt.engine)
+
+		expected := PROMPT + PROMPT + PROMPT + strings.Join(tt.expected, "\n"+PROMPT) + "\n" + PROMPT
+		if out.String() != expected {
+			t.Errorf("wrong output for engine %s. expected=%q, got=%q", tt.engine, expected, out.String())
+		}
+	}
+}
+
+// TestStartAfterFailedLine checks that a line which fails part way through does not leave bindings behind that can not
+// be used.
+func TestStartAfterFailedLine(t *testing.T) {
+	tests := []struct {
+		input    []string
+		engine   runner.Engine
+		expected []string
+	}{
+		{
+			[]string{"let x = 1 / 0;", "x + 1"},
+			runner.EngineEval,
+			[]string{"Error: 1:11: division by zero", "Error: 1:1: identifier not found: x"},
+		},
+		{
+			[]string{"let x = 1 / 0;", "x + 1"},
+			runner.EngineVM,
+			[]string{
+				"We ran into some monkey business! Runtime errors:\n\t- 1:11: division by zero",
+				"We ran into some monkey business! Compilation errors:\n\t- 1:1: identifier not found: x",
+			},
+		},
+		// The evaluator runs the first statement before it reaches the error, the whole line fails to compile
+		{
+			[]string{"let a = 1; let b = nope;", "a"},
+			runner.EngineEval,
+			[]string{"Error: 1:20: identifier not found: nope", "1"},
+		},
+		{
+			[]string{"let a = 1; let b = nope;", "a"},
+			runner.EngineVM,
+			[]string{
+				"We ran into some monkey business! Compilation errors:\n\t- 1:20: identifier not found: nope",
+				"We ran into some monkey business! Compilation errors:\n\t- 1:1: identifier not found: a",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(strings.Join(tt.input, "\n")), &out, tt.engine)
+
+		expected := PROMPT + strings.Join(tt.expected, "\n"+PROMPT) + "\n" + PROMPT
+		if out.String() != expected {
+			t.Errorf("wrong output for %q with engine %s. expected=%q, got=%q", tt.input, tt.engine, expected, out.String())
+		}
+	}
+}
+
+func TestStartRecoversFromPanics(t *testing.T) {
+	input := strings.Join([]string{
+		"let m = macro() { 1 }; m()",
+		"1 + 1",
+	}, "\n")
+
+	for _, engine := range []runner.Engine{runner.EngineEval, runner.EngineVM} {
+		var out bytes.Buffer
+		Start(strings.NewReader(input), &out, engine)
+
+		expected := PROMPT +
+			"We ran into some monkey business! Internal errors:\n" +
+			"\t- only AST nodes can be returned from macros, i.e. only Quote objects can be returned\n" +
+			PROMPT + "2\n" + PROMPT
+		if out.String() != expected {
+			t.Errorf("wrong output for engine %s. expected=%q, got=%q", engine, expected, out.String())
+		}
+	}
+}
+
+// TestTruthinessConformance checks that every engine agrees on which values are truthy, in conditions, negations and
+// logical expressions alike.
+func TestTruthinessConformance(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"null", false},
+		{"false", false},
+		{"true", true},
+		{"0", false},
+		{"1", true},
+		{"-1", true},
+		{"2 ** 64", true},
+		{"0.0", false},
+		{"0.5", true},
+		{`""`, false},
+		{`"a"`, true},
+		{"[]", false},
+		{"[0]", true},
+		{"{}", false},
+		{`{"a": 0}`, true},
+		{"fn() {}", true},
+		{"len", true},
+	}
+
+	for _, tt := range tests {
+		value := strings.ReplaceAll(tt.value, "null", "if (false) { 1 }")
+		input := strings.Join([]string{
+			"let v = " + value + ";",
+			"if (v) { true } else { false }",
+			"!!v",
+			"v && true",
+			"v || false",
+			"let n = 0; while (v) { let n = n + 1; break; }; n == 1",
+		}, "\n")
+
+		expected := PROMPT + strings.Repeat(fmt.Sprintf("%s%t\n", PROMPT, tt.expected), 5)
+		for _, engine := range []runner.Engine{runner.EngineEval, runner.EngineVM} {
+			var out bytes.Buffer
+			Start(strings.NewReader(input), &out, engine)
+
+			if out.String() != expected+PROMPT {
+				t.Errorf("wrong truthiness of %s for engine %s. expected=%q, got=%q", tt.value, engine, expected+PROMPT, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,572 @@
+package runner
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/grantwforsythe/monkeylang/pkg/ast"
+	"github.com/grantwforsythe/monkeylang/pkg/evaluator"
+	"github.com/grantwforsythe/monkeylang/pkg/lexer"
+	"github.com/grantwforsythe/monkeylang/pkg/object"
+	"github.com/grantwforsythe/monkeylang/pkg/parser"
+	"github.com/grantwforsythe/monkeylang/pkg/token"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the conformance tests")
+
+// TestConformance runs every program in testdata/conformance with each engine and checks that the engines agree with
+// each other and with the program's golden file. Run with -update to regenerate the golden files.
+func TestConformance(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.monkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) == 0 {
+		t.Fatal("no conformance programs found")
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".monkey")
+		t.Run(name, func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			program := parseConformanceProgram(t, string(source))
+
+			evalOutput := executeForConformance(program, EngineEval)
+			vmOutput := executeForConformance(program, EngineVM)
+			if !outputsAgree(evalOutput, vmOutput) {
+				t.Fatalf("engines disagree.\neval: %s\nvm:   %s", evalOutput, vmOutput)
+			}
+
+			golden := strings.TrimSuffix(file, ".monkey") + ".golden"
+			if *update {
+				err := os.WriteFile(golden, []byte(evalOutput+"\n"), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if evalOutput != strings.TrimSuffix(string(expected), "\n") {
+				t.Errorf("wrong output.\nexpected: %s\ngot:      %s", expected, evalOutput)
+			}
+		})
+	}
+}
+
+// FuzzConformance builds a random program from the fuzzer's input and checks that each engine produces the same result
+// for it, see programGenerator for the programs it builds. Only the final values of the generated variables and the
+// errors are compared, so a divergence that does not change either of them goes unnoticed, as does anything the
+// generator never builds, e.g. macros. A program the compiler rejects is a known difference unless the evaluator fails
+// with the same error, see outputsAgree.
+func FuzzConformance(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{2, 2, 0, 1, 0, 3})
+	f.Add([]byte{3, 2, 0, 5, 0, 7, 1, 0, 2})
+	f.Add([]byte{4, 3, 1, 0, 2, 5, 6, 0, 4})
+	f.Add([]byte{8, 2, 2, 9, 0, 1, 0, 2, 7})
+	f.Add([]byte{2, 0, 4, 1, 2, 6, 5, 3, 1, 1, 7, 0, 0, 2, 3, 1, 2})
+	f.Add([]byte{2, 1, 3, 2, 1, 1, 0, 0, 1, 2, 9, 1, 4, 3, 0, 0, 1, 5, 2, 1, 2})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		g := &programGenerator{data: data}
+		program := g.program()
+
+		evalOutput := executeForConformance(program, EngineEval)
+		vmOutput := executeForConformance(program, EngineVM)
+		if outputsAgree(evalOutput, vmOutput) {
+			return
+		}
+
+		if strings.HasPrefix(vmOutput, compilationErrorPrefix) {
+			t.Skipf("known difference on %s\neval: %s\nvm:   %s", program.String(), evalOutput, vmOutput)
+		}
+		t.Fatalf("engines disagree on %s\neval: %s\nvm:   %s", program.String(), evalOutput, vmOutput)
+	})
+}
+
+const (
+	compilationErrorPrefix = "Compilation error: "
+	runtimeErrorPrefix     = "Runtime error: "
+)
+
+// outputsAgree reports whether the outputs of the evaluator and the VM for a program agree. The compiler rejects a
+// static error, e.g. an undefined identifier or an assignment to a builtin, before anything runs, while the evaluator
+// reports the same error when it reaches it, so a compilation error agrees with a runtime error of the same messages.
+// Any other difference is a divergence, although one caused by a compilation error is known: the evaluator may fail
+// with another error first, or never reach the statement at all.
+func outputsAgree(evalOutput, vmOutput string) bool {
+	if evalOutput == vmOutput {
+		return true
+	}
+
+	messages, ok := strings.CutPrefix(vmOutput, compilationErrorPrefix)
+	return ok && evalOutput == runtimeErrorPrefix+messages
+}
+
+func parseConformanceProgram(t *testing.T, source string) ast.Node {
+	t.Helper()
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	for _, err := range p.Errors() {
+		t.Fatalf("parse error: %s", err.Render(source))
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+
+	return evaluator.ExpandMacros(program, macroEnv)
+}
+
+// executeForConformance runs a program and formats its result so that the output of the engines can be compared.
+func executeForConformance(program ast.Node, engine Engine) string {
+	value, err := execute(program, engine)
+	if err != nil {
+		// Only the evaluator records stack traces so they are left out of the comparison
+		messages := []string{}
+		for _, msg := range err.Messages {
+			messages = append(messages, strings.SplitN(msg, "\n", 2)[0])
+		}
+
+		return err.Kind + " error: " + strings.Join(messages, "; ")
+	}
+
+	if value == nil {
+		return "<no value>"
+	}
+	return value.Inspect()
+}
+
+// programGenerator builds random programs. Every choice it makes is read from its data, so the same data always
+// produces the same program, which lets the fuzzer minimise and replay the inputs it finds.
+//
+// A program is a sequence of statements: let statements, assignments, if expressions, loops and calls, which may
+// create closures that capture and assign variables of the enclosing scopes. Return, break and continue statements can
+// be nested inside of an expression. It ends with an array of the variables it defined, so that their final values are
+// compared. Programs are built so that they always terminate:
+//   - a, b and c hold values at the top level, d and e in a function, l and m in a function nested inside of it and so
+//     on. They can be read and assigned anywhere they are in scope, even if their let statement may not have run.
+//   - f and g hold functions with the parameter x, they are called or reassigned, but not by a generated function.
+//   - i, j and k are loop counters, they are only read by the body of their loop.
+//   - z is never defined and len can not be assigned, they are rarely used to check the errors of the engines agree.
+//
+// The evaluator looks up a variable when it is read, while the compiler resolves it up front, so the engines would read
+// different variables when a function defines a name after a closure or an earlier iteration of a loop read it from an
+// enclosing scope. Each level of functions has its own names so that this never happens. The VM also copies a variable
+// into a closure when the closure is created, which fails if the variable is not set yet, while the evaluator only
+// fails if the closure reads it, so a function can not refer to the variables of an enclosing function that may not
+// be set.
+type programGenerator struct {
+	data []byte
+}
+
+// valueNames represents the variables holding values which can be defined at each level of functions.
+var valueNames = [][]string{{"a", "b", "c"}, {"d", "e"}, {"l", "m"}, {"n", "o"}, {"p", "q"}}
+
+// scope represents the variables a generated statement can refer to and the control flow it can use.
+type scope struct {
+	values    []string        // values represents the variables in scope which hold values.
+	functions []string        // functions represents the variables in scope which hold functions.
+	counters  []string        // counters represents the loop counters in scope.
+	unset     map[string]bool // unset represents the variables in scope whose let statement may not have run.
+	level     int             // level represents how many functions the scope is nested in.
+	parameter bool            // parameter represents whether the parameter x is in scope.
+	canReturn bool            // canReturn represents whether a return statement can be used.
+	canBreak  bool            // canBreak represents whether a break or continue statement can be used.
+}
+
+// copy creates a copy of the scope for a nested block.
+func (s *scope) copy() *scope {
+	c := *s
+	c.values = append([]string{}, s.values...)
+	c.functions = append([]string{}, s.functions...)
+	c.counters = append([]string{}, s.counters...)
+	c.unset = make(map[string]bool, len(s.unset))
+	for name := range s.unset {
+		c.unset[name] = true
+	}
+
+	return &c
+}
+
+// leak adds the variables defined by a nested block to the scope. A let statement inside of a block defines a variable
+// of the enclosing function, but the block may not run, so they may not be set.
+func (s *scope) leak(nested *scope) {
+	for _, name := range nested.values {
+		if !slices.Contains(s.values, name) {
+			s.values = append(s.values, name)
+			s.unset[name] = true
+		}
+	}
+
+	for _, name := range nested.functions {
+		if !slices.Contains(s.functions, name) {
+			s.functions = append(s.functions, name)
+			s.unset[name] = true
+		}
+	}
+}
+
+// choose picks a number in the range [0, n), once the data runs out it always picks 0.
+func (g *programGenerator) choose(n int) int {
+	if len(g.data) == 0 {
+		return 0
+	}
+
+	b := g.data[0]
+	g.data = g.data[1:]
+
+	return int(b) % n
+}
+
+// program generates a program followed by an array of the values of the variables it defined. A variable that may not
+// be set is only sometimes added, as reading it would often hide the values of the others behind an error.
+func (g *programGenerator) program() *ast.Program {
+	s := &scope{unset: map[string]bool{}}
+	statements := g.statements(4, s)
+
+	array := &ast.ArrayLiteral{Token: token.Token{Type: token.LBRACKET, Literal: "["}}
+	for _, name := range s.values {
+		if !s.unset[name] || g.choose(2) == 0 {
+			array.Elements = append(array.Elements, identifier(name))
+		}
+	}
+	statements = append(statements, &ast.ExpressionStatement{Expression: array})
+
+	return &ast.Program{Statements: statements}
+}
+
+// statements generates between one and three statements that are at most depth levels deep. The variables they define
+// are added to the scope.
+func (g *programGenerator) statements(depth int, s *scope) []ast.Statement {
+	statements := []ast.Statement{}
+	for i := g.choose(3); i >= 0; i-- {
+		statements = append(statements, g.statement(depth, s)...)
+	}
+
+	return statements
+}
+
+// statement generates a statement that is at most depth levels deep, a while loop is preceded by the let statement
+// defining its counter.
+func (g *programGenerator) statement(depth int, s *scope) []ast.Statement {
+	if depth <= 0 {
+		return []ast.Statement{&ast.ExpressionStatement{Expression: g.literal(s)}}
+	}
+
+	switch g.choose(8) {
+	case 0:
+		name := valueNames[s.level][g.choose(len(valueNames[s.level]))]
+
+		stmt := &ast.LetStatement{Token: token.Token{Type: token.LET, Literal: "let"}, Name: identifier(name), Value: g.expression(depth-1, s)}
+		if !slices.Contains(s.values, name) {
+			s.values = append(s.values, name)
+		}
+		delete(s.unset, name)
+		return []ast.Statement{stmt}
+	case 1:
+		if g.choose(32) == 0 {
+			return []ast.Statement{g.assignment(depth, s, "len")}
+		}
+
+		if len(s.values) == 0 {
+			return g.statement(depth, s)
+		}
+		return []ast.Statement{g.assignment(depth, s, s.values[g.choose(len(s.values))])}
+	case 2:
+		names := []string{"f", "g"}
+		name := names[g.choose(len(names))]
+
+		fn := g.function(depth-1, s)
+		if slices.Contains(s.functions, name) && g.choose(2) == 0 {
+			return []ast.Statement{&ast.ExpressionStatement{Expression: &ast.AssignExpression{
+				Token:    token.Token{Type: token.ASSIGN, Literal: "="},
+				Target:   identifier(name),
+				Operator: "=",
+				Value:    fn,
+			}}}
+		}
+
+		// The parser names a function bound by a let statement, which the compiler relies on for recursion
+		fn.Name = name
+		if !slices.Contains(s.functions, name) {
+			s.functions = append(s.functions, name)
+		}
+		delete(s.unset, name)
+		return []ast.Statement{&ast.LetStatement{Token: token.Token{Type: token.LET, Literal: "let"}, Name: identifier(name), Value: fn}}
+	case 3:
+		exp := &ast.IfExpression{
+			Token:       token.Token{Type: token.IF, Literal: "if"},
+			Condition:   g.expression(depth-1, s),
+			Consequence: g.block(depth-1, s),
+		}
+		if g.choose(2) == 1 {
+			exp.Alternative = g.block(depth-1, s)
+		}
+		return []ast.Statement{&ast.ExpressionStatement{Expression: exp}}
+	case 4, 5:
+		counters := []string{"i", "j", "k"}
+		if len(s.counters) == len(counters) {
+			return []ast.Statement{&ast.ExpressionStatement{Expression: g.expression(depth-1, s)}}
+		}
+		counter := counters[len(s.counters)]
+
+		init := &ast.LetStatement{
+			Token: token.Token{Type: token.LET, Literal: "let"},
+			Name:  identifier(counter),
+			Value: (&object.Integer{Value: 0}).ToNode().(ast.Expression),
+		}
+		condition := &ast.InfixExpression{
+			Token:    token.Token{Type: token.LT, Literal: "<"},
+			Left:     identifier(counter),
+			Operator: "<",
+			Right:    (&object.Integer{Value: int64(g.choose(4))}).ToNode().(ast.Expression),
+		}
+		increment := &ast.ExpressionStatement{Expression: &ast.AssignExpression{
+			Token:    token.Token{Type: token.PLUS_ASSIGN, Literal: "+="},
+			Target:   identifier(counter),
+			Operator: "+=",
+			Value:    (&object.Integer{Value: 1}).ToNode().(ast.Expression),
+		}}
+
+		body := s.copy()
+		body.counters = append(body.counters, counter)
+		body.canBreak = true
+
+		block := g.block(depth-1, body)
+		s.leak(body)
+
+		if g.choose(2) == 0 {
+			return []ast.Statement{&ast.ForStatement{
+				Token:     token.Token{Type: token.FOR, Literal: "for"},
+				Init:      init,
+				Condition: condition,
+				Post:      increment,
+				Body:      block,
+			}}
+		}
+
+		// The counter is incremented first so that a continue statement can not skip it
+		block.Statements = append([]ast.Statement{increment}, block.Statements...)
+		return []ast.Statement{init, &ast.WhileStatement{
+			Token:     token.Token{Type: token.WHILE, Literal: "while"},
+			Condition: condition,
+			Body:      block,
+		}}
+	case 6:
+		switch {
+		case s.canBreak && g.choose(2) == 0:
+			if g.choose(2) == 0 {
+				return []ast.Statement{&ast.BreakStatement{Token: token.Token{Type: token.BREAK, Literal: "break"}}}
+			}
+			return []ast.Statement{&ast.ContinueStatement{Token: token.Token{Type: token.CONTINUE, Literal: "continue"}}}
+		case s.canReturn:
+			return []ast.Statement{&ast.ReturnStatement{
+				Token:       token.Token{Type: token.RETURN, Literal: "return"},
+				ReturnValue: g.expression(depth-1, s),
+			}}
+		}
+		fallthrough
+	default:
+		return []ast.Statement{&ast.ExpressionStatement{Expression: g.expression(depth-1, s)}}
+	}
+}
+
+// assignment generates an assignment or compound assignment to the variable name.
+func (g *programGenerator) assignment(depth int, s *scope, name string) ast.Statement {
+	operators := []token.TokenType{token.ASSIGN, token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.ASTERISK_ASSIGN, token.SLASH_ASSIGN}
+	operator := operators[g.choose(len(operators))]
+
+	return &ast.ExpressionStatement{Expression: &ast.AssignExpression{
+		Token:    token.Token{Type: operator, Literal: string(operator)},
+		Target:   identifier(name),
+		Operator: string(operator),
+		Value:    g.expression(depth-1, s),
+	}}
+}
+
+// function generates a function literal with the parameter x. The function can read and assign the values of the
+// enclosing scopes, apart from the locals of an enclosing function that may not be set, but can not call the functions,
+// which could recurse without end.
+func (g *programGenerator) function(depth int, s *scope) *ast.FunctionLiteral {
+	body := &scope{
+		counters:  append([]string{}, s.counters...),
+		unset:     map[string]bool{},
+		level:     s.level + 1,
+		parameter: true,
+		canReturn: true,
+	}
+	for _, name := range s.values {
+		global := slices.Contains(valueNames[0], name)
+		if s.unset[name] && !global {
+			continue
+		}
+
+		body.values = append(body.values, name)
+		if s.unset[name] {
+			body.unset[name] = true
+		}
+	}
+
+	return &ast.FunctionLiteral{
+		Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+		Parameters: []*ast.Identifier{identifier("x")},
+		Body:       g.block(depth, body),
+	}
+}
+
+// block generates a block of statements in a copy of the scope, the variables it defines are leaked into the scope.
+func (g *programGenerator) block(depth int, s *scope) *ast.BlockStatement {
+	nested := s.copy()
+	block := &ast.BlockStatement{
+		Token:      token.Token{Type: token.LBRACE, Literal: "{"},
+		Statements: g.statements(depth, nested),
+	}
+	s.leak(nested)
+
+	return block
+}
+
+// expression generates an expression that is at most depth levels deep.
+func (g *programGenerator) expression(depth int, s *scope) ast.Expression {
+	if depth <= 0 {
+		return g.literal(s)
+	}
+
+	switch g.choose(12) {
+	case 0:
+		return g.literal(s)
+	case 1:
+		operators := []string{"!", "-"}
+		operator := operators[g.choose(len(operators))]
+		return &ast.PrefixExpression{
+			Token:    token.Token{Type: token.TokenType(operator), Literal: operator},
+			Operator: operator,
+			Right:    g.expression(depth-1, s),
+		}
+	case 2:
+		operators := []string{"+", "-", "*", "/", "%", "**", "<", ">", "<=", ">=", "==", "!=", "&&", "||"}
+		operator := operators[g.choose(len(operators))]
+		return &ast.InfixExpression{
+			Token:    token.Token{Type: token.TokenType(operator), Literal: operator},
+			Left:     g.expression(depth-1, s),
+			Operator: operator,
+			Right:    g.expression(depth-1, s),
+		}
+	case 3:
+		exp := &ast.IfExpression{
+			Token:       token.Token{Type: token.IF, Literal: "if"},
+			Condition:   g.expression(depth-1, s),
+			Consequence: g.block(depth-1, s),
+		}
+		if g.choose(2) == 1 {
+			exp.Alternative = g.block(depth-1, s)
+		}
+		return exp
+	case 4:
+		array := &ast.ArrayLiteral{Token: token.Token{Type: token.LBRACKET, Literal: "["}}
+		for i := g.choose(4); i > 0; i-- {
+			array.Elements = append(array.Elements, g.expression(depth-1, s))
+		}
+		return array
+	case 5:
+		// A hash has at most one pair, the order in which the pairs of a literal are evaluated is not defined
+		hash := &ast.HashLiteral{Token: token.Token{Type: token.LBRACE, Literal: "{"}, Pairs: map[ast.Expression]ast.Expression{}}
+		if g.choose(2) == 1 {
+			hash.Pairs[g.expression(depth-1, s)] = g.expression(depth-1, s)
+		}
+		return hash
+	case 6:
+		return &ast.IndexEpression{
+			Token: token.Token{Type: token.LBRACKET, Literal: "["},
+			Left:  g.expression(depth-1, s),
+			Index: g.expression(depth-1, s),
+		}
+	case 7:
+		builtins := []string{"len", "runelen", "first", "last", "rest", "push"}
+		name := builtins[g.choose(len(builtins))]
+		call := &ast.CallExpression{
+			Token:    token.Token{Type: token.LPAREN, Literal: "("},
+			Function: identifier(name),
+		}
+		for i := g.choose(3); i > 0; i-- {
+			call.Arguments = append(call.Arguments, g.expression(depth-1, s))
+		}
+		return call
+	case 8:
+		return &ast.CallExpression{
+			Token:     token.Token{Type: token.LPAREN, Literal: "("},
+			Function:  g.function(depth-1, s),
+			Arguments: []ast.Expression{g.expression(depth-1, s)},
+		}
+	case 9:
+		if len(s.functions) == 0 {
+			return g.literal(s)
+		}
+		return &ast.CallExpression{
+			Token:     token.Token{Type: token.LPAREN, Literal: "("},
+			Function:  identifier(s.functions[g.choose(len(s.functions))]),
+			Arguments: []ast.Expression{g.expression(depth-1, s)},
+		}
+	case 10:
+		return g.function(depth-1, s)
+	default:
+		parts := []ast.Expression{g.stringLiteral(), g.expression(depth-1, s), g.stringLiteral()}
+		return &ast.InterpolatedString{Token: token.Token{Type: token.STRING_HEAD, Literal: ""}, Parts: parts}
+	}
+}
+
+// literal generates an integer, float, string or boolean literal, or a reference to a value, loop counter, parameter or
+// the undefined variable z.
+func (g *programGenerator) literal(s *scope) ast.Expression {
+	switch g.choose(5) {
+	case 0:
+		values := []int64{0, 1, 2, 3, 10, 9223372036854775807}
+		value := values[g.choose(len(values))]
+		return (&object.Integer{Value: value}).ToNode().(ast.Expression)
+	case 1:
+		values := []float64{0, 0.5, 2}
+		value := values[g.choose(len(values))]
+		return (&object.Float{Value: value}).ToNode().(ast.Expression)
+	case 2:
+		return g.stringLiteral()
+	case 3:
+		names := append(append([]string{}, s.values...), s.counters...)
+		if s.parameter {
+			names = append(names, "x")
+		}
+		if len(names) > 0 {
+			return identifier(names[g.choose(len(names))])
+		}
+		fallthrough
+	default:
+		value := g.choose(32)
+		if value == 0 {
+			return identifier("z")
+		}
+		return (&object.Boolean{Value: value%2 == 1}).ToNode().(ast.Expression)
+	}
+}
+
+func (g *programGenerator) stringLiteral() *ast.StringLiteral {
+	values := []string{"", "a", "monkey", "héllo"}
+	value := values[g.choose(len(values))]
+	return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value}, Value: value}
+}
+
+func identifier(name string) *ast.Identifier {
+	return &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+}
@@ -0,0 +1,141 @@
+// Package runner executes complete Monkey programs, e.g. the contents of a script file.
+package runner
+
+import (
+	"strings"
+
+	"github.com/grantwforsythe/monkeylang/pkg/ast"
+	"github.com/grantwforsythe/monkeylang/pkg/compiler"
+	"github.com/grantwforsythe/monkeylang/pkg/evaluator"
+	"github.com/grantwforsythe/monkeylang/pkg/lexer"
+	"github.com/grantwforsythe/monkeylang/pkg/object"
+	"github.com/grantwforsythe/monkeylang/pkg/parser"
+	"github.com/grantwforsythe/monkeylang/pkg/vm"
+)
+
+// Engine represents the backend used to execute Monkey programs.
+type Engine string
+
+const (
+	EngineEval Engine = "eval" // EngineEval executes programs with the tree-walking evaluator.
+	EngineVM   Engine = "vm"   // EngineVM compiles programs into bytecode and executes them with the virtual machine.
+)
+
+// Error represents one or more errors that stopped a program from running to completion.
+type Error struct {
+	Kind     string   // Kind represents the stage in which the errors occurred, i.e. Parse, Compilation, or Runtime.
+	Messages []string // Messages represents the message of each error.
+}
+
+func (e *Error) Error() string {
+	var out strings.Builder
+
+	out.WriteString("We ran into some monkey business! " + e.Kind + " errors:")
+	for _, msg := range e.Messages {
+		// Indent the continuation lines of multi-line messages, e.g. diagnostics, so they stay under their bullet
+		out.WriteString("\n\t- " + strings.ReplaceAll(msg, "\n", "\n\t  "))
+	}
+
+	return out.String()
+}
+
+// Run parses, expands the macros of, and executes a program with the given engine.
+// The file is the name the program was read from and is used when reporting the positions of errors, it may be empty.
+// Any output from the program, e.g. calls to puts, is written to stdout.
+// Returns an *Error if the program could not be parsed or failed while running.
+func Run(file string, input string, engine Engine) error {
+	program, err := parse(file, input)
+	if err != nil {
+		return err
+	}
+
+	_, err = execute(program, engine)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Compile parses, expands the macros of, and compiles a program so that it can be saved and run later.
+// The file is the name the program was read from and is kept in the bytecode for reporting the positions of errors.
+// Returns an *Error if the program could not be parsed or compiled.
+func Compile(file string, input string) (*compiler.ByteCode, error) {
+	program, err := parse(file, input)
+	if err != nil {
+		return nil, err
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, &Error{Kind: "Compilation", Messages: []string{err.Error()}}
+	}
+
+	return comp.ByteCode(), nil
+}
+
+// RunByteCode executes a program that has already been compiled with the virtual machine.
+// Any output from the program, e.g. calls to puts, is written to stdout.
+// Returns an *Error if the program failed while running.
+func RunByteCode(bytecode *compiler.ByteCode) error {
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		return &Error{Kind: "Runtime", Messages: []string{err.Error()}}
+	}
+
+	return nil
+}
+
+// parse parses a program and expands its macros.
+// Returns an *Error if the program could not be parsed.
+func parse(file string, input string) (ast.Node, *Error) {
+	l := lexer.NewWithFile(file, input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		messages := []string{}
+		for _, err := range p.Errors() {
+			messages = append(messages, err.Render(input))
+		}
+
+		return nil, &Error{Kind: "Parse", Messages: messages}
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+
+	return evaluator.ExpandMacros(program, macroEnv), nil
+}
+
+// execute runs a program, whose macros have already been expanded, with the given engine.
+// Returns the value of the last statement that was executed, or an *Error if the program failed to compile or run.
+func execute(program ast.Node, engine Engine) (object.Object, *Error) {
+	if engine == EngineVM {
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			return nil, &Error{Kind: "Compilation", Messages: []string{err.Error()}}
+		}
+
+		machine := vm.New(comp.ByteCode())
+		err = machine.Run()
+		if err != nil {
+			return nil, &Error{Kind: "Runtime", Messages: []string{err.Error()}}
+		}
+
+		return machine.LastPoppedStackElem(), nil
+	}
+
+	eval := evaluator.Eval(program, object.NewEnvironment())
+	if err, ok := eval.(*object.Error); ok {
+		msg := err.Error()
+		for _, line := range err.StackTrace() {
+			msg += "\n" + line
+		}
+
+		return nil, &Error{Kind: "Runtime", Messages: []string{msg}}
+	}
+
+	return eval, nil
+}
@@ -0,0 +1,148 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/grantwforsythe/monkeylang/pkg/compiler"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedKind  string
+		expectedError string
+	}{
+		{"let add = fn(x, y) { x + y }; add(1, 2);", "", ""},
+		{"// Adds two numbers\nlet add = fn(x, y) { x /* + 0 */ + y }; add(1, 2); // => 3", "", ""},
+		{
+			"let unless = macro(cond, cons) { quote(if (!(unquote(cond))) { unquote(cons) }) }; unless(false, 1);",
+			"",
+			"",
+		},
+		{
+			"let x = ;",
+			"Parse",
+			"We ran into some monkey business! Parse errors:\n\t- 1:9: no prefix parse function for ;\n\t   1 | let x = ;\n\t     |         ^",
+		},
+		{
+			"let x = 5; { x }",
+			"Parse",
+			"We ran into some monkey business! Parse errors:\n\t- 1:16: expected next token to be :. got=}\n\t   1 | let x = 5; { x }\n\t     |                ^",
+		},
+		{
+			"1 + true; 2;",
+			"Runtime",
+			"We ran into some monkey business! Runtime errors:\n\t- 1:3: type mismatch: INTEGER + BOOLEAN",
+		},
+	}
+
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			err := Run("", tt.input, engine)
+
+			if tt.expectedKind == "" {
+				if err != nil {
+					t.Errorf("[%s] unexpected error for %q: %s", engine, tt.input, err)
+				}
+				continue
+			}
+
+			var runErr *Error
+			if !errors.As(err, &runErr) {
+				t.Errorf("[%s] expected *Error for %q. got=%T (%+v)", engine, tt.input, err, err)
+				continue
+			}
+
+			if runErr.Kind != tt.expectedKind {
+				t.Errorf("[%s] wrong error kind. expected=%q, got=%q", engine, tt.expectedKind, runErr.Kind)
+			}
+
+			if runErr.Error() != tt.expectedError {
+				t.Errorf("[%s] wrong error. expected=%q, got=%q", engine, tt.expectedError, runErr.Error())
+			}
+		}
+	}
+}
+
+func TestRunCompilationError(t *testing.T) {
+	err := Run("", "foobar", EngineVM)
+
+	var runErr *Error
+	if !errors.As(err, &runErr) {
+		t.Fatalf("expected *Error. got=%T (%+v)", err, err)
+	}
+
+	if runErr.Kind != "Compilation" {
+		t.Errorf("wrong error kind. expected=%q, got=%q", "Compilation", runErr.Kind)
+	}
+}
+
+func TestRunReportsFile(t *testing.T) {
+	err := Run("script.monkey", "let x = 1;\nx + true;", EngineEval)
+
+	expected := "We ran into some monkey business! Runtime errors:\n\t- script.monkey:2:3: type mismatch: INTEGER + BOOLEAN"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%v", expected, err)
+	}
+}
+
+func TestRunReportsStackTrace(t *testing.T) {
+	err := Run("", "let double = fn(x) { x * 2 };\ndouble(true);", EngineEval)
+
+	expected := "We ran into some monkey business! Runtime errors:\n" +
+		"\t- 1:24: type mismatch: BOOLEAN * INTEGER\n" +
+		"\t  at double (2:7)"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%v", expected, err)
+	}
+}
+
+func TestRunByteCode(t *testing.T) {
+	bytecode, err := Compile("script.monkey", "let add = fn(x, y) { x + y };\nadd(1, 2);\nadd(1, true);")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+
+	loaded := &compiler.ByteCode{}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %s", err)
+	}
+
+	// The positions of errors still point at the script the bytecode was compiled from
+	err = RunByteCode(loaded)
+
+	expected := "We ran into some monkey business! Runtime errors:\n\t- script.monkey:1:24: type mismatch: INTEGER + BOOLEAN"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%v", expected, err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedKind string
+	}{
+		{"let x = ;", "Parse"},
+		{"foobar", "Compilation"},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile("", tt.input)
+
+		var runErr *Error
+		if !errors.As(err, &runErr) {
+			t.Errorf("expected *Error for %q. got=%T (%+v)", tt.input, err, err)
+			continue
+		}
+
+		if runErr.Kind != tt.expectedKind {
+			t.Errorf("wrong error kind. expected=%q, got=%q", tt.expectedKind, runErr.Kind)
+		}
+	}
+}
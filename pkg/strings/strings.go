@@ -1,17 +1,24 @@
 // Package strings contains utility functions for working with strings.
 package strings
 
+import "unicode"
+
 // Determine if a character is whitespace or not
-func IsWhiteSpace(ch byte) bool {
+func IsWhiteSpace(ch rune) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
 
-// Determine if a charater is a letter or not
-func IsLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// Determine if a charater is a letter or not, including letters outside of ASCII, e.g. 'é' or 'λ'
+func IsLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch > unicode.MaxASCII && unicode.IsLetter(ch)
 }
 
-// Determine if a charater is a digit or not
-func IsDigit(ch byte) bool {
+// Determine if a charater is a digit or not, only ASCII digits are considered digits
+func IsDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
+
+// Determine if a charater is a hexadecimal digit or not
+func IsHexDigit(ch rune) bool {
+	return IsDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
@@ -4,11 +4,12 @@ import "testing"
 
 func TestIsDigit(t *testing.T) {
 	tests := []struct {
-		ch       byte
+		ch       rune
 		expected bool
 	}{
 		{'5', true},
 		{'a', false},
+		{'٣', false},
 	}
 
 	for _, tt := range tests {
@@ -20,13 +21,17 @@ func TestIsDigit(t *testing.T) {
 
 func TestIsLetter(t *testing.T) {
 	tests := []struct {
-		ch       byte
+		ch       rune
 		expected bool
 	}{
 		{'a', true},
 		{'5', false},
 		{'_', true},
 		{'$', false},
+		{'é', true},
+		{'λ', true},
+		{'界', true},
+		{'😀', false},
 	}
 
 	for _, tt := range tests {
@@ -38,7 +43,7 @@ func TestIsLetter(t *testing.T) {
 
 func TestIsWhiteSpace(t *testing.T) {
 	tests := []struct {
-		ch       byte
+		ch       rune
 		expected bool
 	}{
 		{'\n', true},
@@ -55,3 +60,22 @@ func TestIsWhiteSpace(t *testing.T) {
 		}
 	}
 }
+
+func TestIsHexDigit(t *testing.T) {
+	tests := []struct {
+		ch       rune
+		expected bool
+	}{
+		{'5', true},
+		{'a', true},
+		{'F', true},
+		{'g', false},
+		{'$', false},
+	}
+
+	for _, tt := range tests {
+		if got := IsHexDigit(tt.ch); got != tt.expected {
+			t.Errorf("IsHexDigit() = %v, expected %v", got, tt.expected)
+		}
+	}
+}
@@ -1,36 +1,52 @@
 // Package token contains all of the tokens for the Monkey language.
 package token
 
+import "fmt"
+
 type TokenType string
 
 // TODO: Replace assignment operator `let` with `:=`
 // TODO: Replace fn defintion with func
-// TODO: Add a loop token
-// TODO: Add less/greater than or equal to operators
-// TODO: Add comment token that will stop evaluation of a line
-// TODO: Add exponent operator
 
 const (
 	ILLEGAL = "ILLEGAL" // Unrecognized token
 	EOF     = "EOF"     // End of file
 
 	IDENT  = "IDENT"  // Identifier, e.g. add, foobar, x, y
-	INT    = "INT"    // Integer literal, e.g. 1234
+	INT    = "INT"    // Integer literal, e.g. 1234, 0xFF, 0o17, 0b1010 or 1_000
+	FLOAT  = "FLOAT"  // Floating point literal, e.g. 3.14 or 1e9
 	STRING = "STRING" // String literal, "Hello, World!"
 
+	STRING_HEAD   = "STRING_HEAD"   // The start of an interpolated string up to the first interpolation, e.g. "Hello, ${
+	STRING_MIDDLE = "STRING_MIDDLE" // The part of an interpolated string between two interpolations, e.g. } and ${
+	STRING_TAIL   = "STRING_TAIL"   // The end of an interpolated string after the last interpolation, e.g. }!"
+
 	ASSIGN   = "=" // Assignment operator, "="
 	PLUS     = "+" // Additional operator, "+"
 	MINUS    = "-" // Subtraction operator, "-"
 	BANG     = "!" // Boolean inversion operator, "!"
 	ASTERISK = "*" // Multiplication operator, "*"
 	SLASH    = "/" // Division operator, "/"
+	PERCENT  = "%" // Remainder operator, "%"
+
+	POWER = "**" // Exponent operator, "**"
+
+	LT    = "<"  // Less than operator, "<"
+	GT    = ">"  // Greater than operator, ">"
+	LT_EQ = "<=" // Less than or equal to operator, "<="
+	GT_EQ = ">=" // Greater than or equal to operator, ">="
 
-	LT = "<" // Less than operator, "<"
-	GT = ">" // Greater than operator, ">"
+	AND = "&&" // Logical and operator, "&&"
+	OR  = "||" // Logical or operator, "||"
 
 	EQ     = "==" // Equality operator, "=="
 	NOT_EQ = "!=" // Inverse equality opertor, "!="
 
+	PLUS_ASSIGN     = "+=" // Addition assignment operator, "+="
+	MINUS_ASSIGN    = "-=" // Subtraction assignment operator, "-="
+	ASTERISK_ASSIGN = "*=" // Multiplication assignment operator, "*="
+	SLASH_ASSIGN    = "/=" // Division assignment operator, "/="
+
 	COMMA     = "," // Comma, ","
 	SEMICOLON = ";" // Semicolon, ";"
 	COLON     = ":" // Colon, ":"
@@ -50,22 +66,61 @@ const (
 	ELSE     = "ELSE"     // Alternative conditional definition, "else"
 	RETURN   = "RETURN"   // Return statement, "return"
 	MACRO    = "MACRO"    // Macro definition, e.g. "macro(x, y)"
+	WHILE    = "WHILE"    // Conditional loop, "while"
+	FOR      = "FOR"      // Counting loop, "for"
+	BREAK    = "BREAK"    // Exit the enclosing loop, "break"
+	CONTINUE = "CONTINUE" // Skip to the next iteration of the enclosing loop, "continue"
 )
 
 type Token struct {
 	Type    TokenType // The type of token
 	Literal string    // The literal string of the token
+	Pos     Position  // The position of the first character of the token
+
+	Comments []Comment // The comments preceding the token, only recorded when the lexer is told to keep comments
+}
+
+// Comment represents a line comment, e.g. "// note", or a block comment, e.g. "/* note */".
+// Comments are trivia rather than tokens, they are attached to the token that follows them.
+type Comment struct {
+	Text string   // The text of the comment, including its delimiters
+	Pos  Position // The position of the first character of the comment
+}
+
+// Position represents a location in the source code.
+type Position struct {
+	File   string // The name of the file, empty if the source did not come from a file
+	Line   int    // The line number, starting at 1
+	Column int    // The column number, starting at 1
+}
+
+// IsValid reports whether the position points to a location in the source code.
+// Nodes that are not created by the parser, e.g. by a macro, do not have a valid position.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String formats the position as file:line:column, omitting the file if it is unknown.
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"macro":  MACRO,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"macro":    MACRO,
+	"while":    WHILE,
+	"for":      FOR,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 // Get the token associated with a keyword.
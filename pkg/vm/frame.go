@@ -0,0 +1,25 @@
+package vm
+
+import (
+	"github.com/grantwforsythe/monkeylang/pkg/code"
+	"github.com/grantwforsythe/monkeylang/pkg/object"
+)
+
+// Frame represents a call frame, aka activation record, which holds the execution-relevant information for a function call.
+type Frame struct {
+	cl *object.Closure // cl represents the closure being executed.
+	ip int             // ip represents the instruction pointer for the frame.
+	// basePointer represents the value of the stackpointer before the function was called.
+	// The local bindings of the function are stored on the stack starting at the base pointer.
+	basePointer int
+}
+
+// NewFrame creates a new frame for a closure.
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+// Instructions gets the instructions of the closure being executed.
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
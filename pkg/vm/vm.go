@@ -4,39 +4,93 @@
 package vm
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
 
 	"github.com/grantwforsythe/monkeylang/pkg/code"
 	"github.com/grantwforsythe/monkeylang/pkg/compiler"
 	"github.com/grantwforsythe/monkeylang/pkg/object"
+	"github.com/grantwforsythe/monkeylang/pkg/token"
 )
 
 // StackSize represents the maximum number of elements in the stack.
-const StackSize = 2048 // This number was abritarily choosen
+// It leaves room for 16 elements, i.e. the function, its locals and its temporary values, in each of MaxFrames nested
+// calls so that deep recursion runs out of frames, the same as in the evaluator, rather than stack.
+const StackSize = 16 * MaxFrames
+
+// GlobalsSize represents the maximum number of global bindings, which is limited by the width of the operand for OpSetGlobal and OpGetGlobal.
+const GlobalsSize = 65536
+
+// MaxFrames represents the maximum depth of nested function calls, the frame of the main program is not counted.
+const MaxFrames = 1024
 
 type VM struct {
-	constants    []object.Object
-	instructions code.Instructions
+	constants []object.Object
 
 	// Instructions
 	stack []object.Object
 	// sp represents a stackpointer which always points to the next free space in the stack.
 	sp int
+
+	// globals represents the store for all global bindings.
+	globals []object.Object
+	// globalNames represents the names of the global bindings by index, which are reported if one is read before it is set.
+	globalNames []string
+
+	// frames represents the stack of call frames, the main program is executed in the bottom most frame.
+	frames []*Frame
+	// framesIndex represents the index of the next free frame.
+	framesIndex int
+
+	// steps represents the number of instructions left to execute before the virtual machine is stopped.
+	steps int
+	// limited represents whether the number of instructions executed is limited by steps at all.
+	limited bool
 }
 
 var TRUE = &object.Boolean{Value: true}
 var FALSE = &object.Boolean{Value: false}
+var NULL = &object.Null{}
 
 // New creates a new virtual machine from bytecode.
 func New(bytecode *compiler.ByteCode) *VM {
+	// The main program is treated as if it were the body of a function without any parameters or locals.
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, SourceMap: bytecode.SourceMap}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	// +1 for the main program which is not a function call
+	frames := make([]*Frame, MaxFrames+1)
+	frames[0] = mainFrame
+
 	return &VM{
-		constants:    bytecode.Constants,
-		instructions: bytecode.Instructions,
-		stack:        make([]object.Object, StackSize),
-		sp:           0,
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+		frames:      frames,
+		framesIndex: 1,
 	}
 }
 
+// NewWithGlobalsState creates a new virtual machine from bytecode which reuses the globals store of a previous virtual machine.
+// This allows global bindings to survive between runs, e.g. between lines in the REPL.
+func NewWithGlobalsState(bytecode *compiler.ByteCode, globals []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = globals
+	return vm
+}
+
+// SetStepBudget limits the number of instructions the virtual machine executes.
+// This guarantees that running untrusted bytecode terminates.
+func (vm *VM) SetStepBudget(steps int) {
+	vm.steps = steps
+	vm.limited = true
+}
+
 // StackTop gets the top element on the stack.
 // Returns nil if the stack is empty.
 func (vm *VM) StackTop() object.Object {
@@ -46,143 +100,197 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
-// lastPoppedStackElem gets the last element popped from the stack. Values are not zero out when they are popped from the stack, instead the stackpointer is decremented.
+// LastPoppedStackElem gets the last element popped from the stack. Values are not zero out when they are popped from the stack, instead the stackpointer is decremented.
 // Returns the object last popped from the stack.
-func (vm *VM) lastPoppedStackElem() object.Object {
+func (vm *VM) LastPoppedStackElem() object.Object {
 	// The stackpointer always points to the next free slot in memory.
 	return vm.stack[vm.sp]
 }
 
-// Run is the fetch-decode-excute cycle for the virtual machine.
+// Error represents an error that occurred while the virtual machine was running.
+type Error struct {
+	Message string         // Message represents the reason the virtual machine stopped.
+	Pos     token.Position // Pos represents the position in the source code of the instruction that was being executed.
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// Run executes the bytecode until it runs out of instructions or an error occurs.
+// Returns an *Error if the bytecode could not be executed to completion.
 func (vm *VM) Run() error {
+	err := vm.run()
+	if err != nil {
+		frame := vm.currentFrame()
+		pos, _ := frame.cl.Fn.SourceMap.Lookup(frame.ip)
+		return &Error{Message: err.Error(), Pos: pos}
+	}
+
+	return nil
+}
+
+// run is the fetch-decode-excute cycle for the virtual machine.
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
 	// The fetch part.
-	for ip := 0; ip < len(vm.instructions); ip++ {
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if vm.limited {
+			if vm.steps <= 0 {
+				return fmt.Errorf("step budget exhausted")
+			}
+			vm.steps--
+		}
+
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
 
 		// The decode part.
-		op := code.Opcode(vm.instructions[ip])
+		op = code.Opcode(ins[ip])
 
 		// The execute part.
 		switch op {
 		case code.OpConstant:
-			index := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			index := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 
 			err := vm.push(vm.constants[index])
 			if err != nil {
 				return err
 			}
 
-		case code.OpEQ:
-			right := vm.pop()
-			left := vm.pop()
+		case code.OpEQ, code.OpNEQ, code.OpGT, code.OpGTE, code.OpLT, code.OpLTE:
+			err := vm.executeComparison(op)
+			if err != nil {
+				return err
+			}
 
-			var val bool
-			switch {
-			case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-				val = left.(*object.Integer).Value == right.(*object.Integer).Value
-			case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
-				val = left.(*object.Boolean).Value == right.(*object.Boolean).Value
-			default:
-				return fmt.Errorf("type mismatch: %s == %s", left.Type(), right.Type())
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow:
+			err := vm.executeBinaryOperation(op)
+			if err != nil {
+				return err
 			}
 
-			err := vm.push(convertBooleanToObject(val))
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			err := vm.push(array)
 			if err != nil {
 				return err
 			}
 
-		case code.OpNEQ:
-			right := vm.pop()
-			left := vm.pop()
+		case code.OpConcat:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
 
-			var val bool
-			switch {
-			case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-				val = left.(*object.Integer).Value != right.(*object.Integer).Value
-			case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
-				val = left.(*object.Boolean).Value != right.(*object.Boolean).Value
-			default:
-				return fmt.Errorf("type mismatch: %s != %s", left.Type(), right.Type())
+			str, err := vm.buildString(vm.sp-numParts, vm.sp)
+			if err != nil {
+				return err
 			}
+			vm.sp = vm.sp - numParts
 
-			err := vm.push(convertBooleanToObject(val))
+			err = vm.push(str)
 			if err != nil {
 				return err
 			}
 
-		case code.OpGT:
-			right := vm.pop()
-			left := vm.pop()
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
 
-			var val bool
-			switch {
-			case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-				val = left.(*object.Integer).Value > right.(*object.Integer).Value
-			default:
-				return fmt.Errorf("type mismatch: %s > %s", left.Type(), right.Type())
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
 			}
+			vm.sp = vm.sp - numElements
 
-			err := vm.push(convertBooleanToObject(val))
+			err = vm.push(hash)
 			if err != nil {
 				return err
 			}
 
-		case code.OpSub:
-			right := vm.pop()
+		case code.OpIndex:
+			index := vm.pop()
 			left := vm.pop()
 
-			// BUG: Handle other object types
-			err := vm.push(
-				&object.Integer{
-					Value: left.(*object.Integer).Value - right.(*object.Integer).Value,
-				},
-			)
+			err := vm.executeIndexExpression(left, index)
 			if err != nil {
 				return err
 			}
 
-		case code.OpMul:
-			right := vm.pop()
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
 			left := vm.pop()
 
-			// BUG: Handle other object types
-			err := vm.push(
-				&object.Integer{
-					Value: left.(*object.Integer).Value * right.(*object.Integer).Value,
-				},
-			)
+			err := vm.executeIndexAssignment(left, index, value)
 			if err != nil {
 				return err
 			}
 
-		case code.OpDiv:
-			right := vm.pop()
-			left := vm.pop()
+		case code.OpDup:
+			count := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			start := vm.sp - count
+			for i := 0; i < count; i++ {
+				err := vm.push(vm.stack[start+i])
+				if err != nil {
+					return err
+				}
+			}
 
-			// BUG: Handle other object types
-			err := vm.push(
-				&object.Integer{
-					Value: left.(*object.Integer).Value / right.(*object.Integer).Value,
-				},
-			)
+		case code.OpCell:
+			err := vm.push(&object.Cell{Value: vm.pop()})
 			if err != nil {
 				return err
 			}
 
-		case code.OpAdd:
-			right := vm.pop()
-			left := vm.pop()
+		case code.OpDeref:
+			cell, ok := vm.pop().(*object.Cell)
+			if !ok {
+				return fmt.Errorf("not a cell")
+			}
+
+			if cell.Value == nil {
+				return fmt.Errorf("identifier not found: %s", cell.Name)
+			}
 
-			// BUG: Handle other object types
-			err := vm.push(
-				&object.Integer{
-					Value: left.(*object.Integer).Value + right.(*object.Integer).Value,
-				},
-			)
+			err := vm.push(cell.Value)
 			if err != nil {
 				return err
 			}
 
+		case code.OpSetCell:
+			cell, ok := vm.pop().(*object.Cell)
+			if !ok {
+				return fmt.Errorf("not a cell")
+			}
+			cell.Value = vm.pop()
+
+		case code.OpEmptyCell:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			cell := &object.Cell{Name: fmt.Sprintf("local %d", index)}
+			if index < len(frame.cl.Fn.Locals) {
+				cell.Name = frame.cl.Fn.Locals[index]
+			}
+			vm.stack[frame.basePointer+index] = cell
+
 		case code.OpTrue:
 			err := vm.push(TRUE)
 			if err != nil {
@@ -190,13 +298,17 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpMinus:
-			operand := vm.pop()
-
-			if operand.Type() != object.INTEGER_OBJ {
-				return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+			var result object.Object
+			switch operand := vm.pop().(type) {
+			case *object.Integer:
+				result = operand.Neg()
+			case *object.Float:
+				result = &object.Float{Value: -operand.Value}
+			default:
+				return fmt.Errorf("unknown operator: -%s", operand.Type())
 			}
 
-			err := vm.push(&object.Integer{Value: -operand.(*object.Integer).Value})
+			err := vm.push(result)
 			if err != nil {
 				return err
 			}
@@ -216,12 +328,589 @@ func (vm *VM) Run() error {
 		case code.OpPop:
 			vm.pop()
 
+		case code.OpJump:
+			position := int(code.ReadUint16(ins[ip+1:]))
+			// -1 because ip is incremented at the end of every cycle.
+			vm.currentFrame().ip = position - 1
+
+		case code.OpJumpNotTruthy:
+			position := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = position - 1
+			}
+
+		case code.OpNull:
+			err := vm.push(NULL)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[index] = vm.pop()
+
+		case code.OpGetGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			// A global is not set if its let statement did not run, e.g. it is in an if expression
+			if vm.globals[index] == nil {
+				return notSetError(vm.globalNames, int(index), "global")
+			}
+
+			err := vm.push(vm.globals[index])
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			index := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(index)] = vm.pop()
+
+		case code.OpGetLocal:
+			index := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			local := vm.stack[frame.basePointer+int(index)]
+			if local == nil {
+				return notSetError(frame.cl.Fn.Locals, int(index), "local")
+			}
+
+			err := vm.push(local)
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			index := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			free := vm.currentFrame().cl.Free
+			if int(index) >= len(free) {
+				return fmt.Errorf("free variable %d does not exist", index)
+			}
+
+			err := vm.push(free[index])
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			index := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[index]
+
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			err := vm.push(vm.currentFrame().cl)
+			if err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.callFunction(int(numArgs))
+			if err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			// Returning from the main program stops execution, leaving the returned value as the last popped element.
+			if vm.framesIndex == 1 {
+				return nil
+			}
+
+			frame := vm.popFrame()
+			// -1 so that the function being called is removed from the stack as well.
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(NULL)
+			if err != nil {
+				return err
+			}
+
 		}
 	}
 
 	return nil
 }
 
+// currentFrame gets the frame that is currently being executed.
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+// pushFrame adds a frame to the top of the frame stack.
+// Returns an error if the maximum number of frames is exceeded.
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex > MaxFrames {
+		return fmt.Errorf("stack overflow: more than %d nested function calls", MaxFrames)
+	}
+
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+
+	return nil
+}
+
+// popFrame removes the top frame from the frame stack.
+// Returns the popped frame.
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// callFunction calls the closure or builtin function sitting below its arguments on the stack.
+func (vm *VM) callFunction(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+// callClosure calls a closure by pushing a new frame.
+// The arguments become the first local bindings of the new frame.
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf(
+			"wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters,
+			numArgs,
+		)
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	// Reserve space on the stack for the local bindings, which are cleared so that reading one before it is set fails
+	// rather than finding whatever a previous call left there.
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow: more than %d elements on the stack", StackSize)
+	}
+	clear(vm.stack[vm.sp : frame.basePointer+cl.Fn.NumLocals])
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+// notSetError reports a global or local binding that is read before it is set, by its name if it is known.
+func notSetError(names []string, index int, kind string) error {
+	if index < len(names) && names[index] != "" {
+		return fmt.Errorf("identifier not found: %s", names[index])
+	}
+	return fmt.Errorf("%s %d is not set", kind, index)
+}
+
+// callBuiltin calls a builtin function with the arguments on the stack and pushes the result onto the stack.
+// An error object returned by the builtin halts the virtual machine.
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	// -1 so that the builtin being called is removed from the stack as well.
+	vm.sp = vm.sp - numArgs - 1
+
+	if err, ok := result.(*object.Error); ok {
+		return errors.New(err.Message)
+	}
+
+	if result == nil {
+		return vm.push(NULL)
+	}
+
+	return vm.push(result)
+}
+
+// pushClosure wraps the compiled function at the given constant index in a closure and pushes it onto the stack.
+// The free variables captured by the closure are taken off the top of the stack.
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: function, Free: free})
+}
+
+// operators maps an opcode to the operator it represents in the source code, used for error messages.
+var operators = map[code.Opcode]string{
+	code.OpAdd: "+",
+	code.OpSub: "-",
+	code.OpMul: "*",
+	code.OpDiv: "/",
+	code.OpMod: "%",
+	code.OpPow: "**",
+	code.OpEQ:  "==",
+	code.OpNEQ: "!=",
+	code.OpGT:  ">",
+	code.OpGTE: ">=",
+	code.OpLT:  "<",
+	code.OpLTE: "<=",
+}
+
+// executeBinaryOperation pops two objects off the stack, applies an arithmetic operator to them and pushes the result onto the stack.
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return vm.executeBinaryIntegerOperation(op, left, right)
+	// An integer mixed with a float is converted to a float
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return vm.executeBinaryStringOperation(op, left, right)
+	case left.Type() != right.Type():
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
+	default:
+		return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+}
+
+// executeBinaryIntegerOperation applies an arithmetic operator to two integers and pushes the result onto the stack.
+func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
+	l := left.(*object.Integer)
+	r := right.(*object.Integer)
+
+	var result *object.Integer
+	switch op {
+	case code.OpAdd:
+		result = l.Add(r)
+	case code.OpSub:
+		result = l.Sub(r)
+	case code.OpMul:
+		var ok bool
+		result, ok = l.Mul(r)
+		if !ok {
+			return fmt.Errorf("integer too large: more than %d bits", object.MaxIntegerBits)
+		}
+	case code.OpDiv:
+		if r.Sign() == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = l.Quo(r)
+	case code.OpMod:
+		if r.Sign() == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = l.Rem(r)
+	case code.OpPow:
+		if r.Sign() < 0 {
+			return fmt.Errorf("negative exponent: %s", r.Inspect())
+		}
+
+		var ok bool
+		result, ok = l.Pow(r)
+		if !ok {
+			return fmt.Errorf("exponent too large: %s", r.Inspect())
+		}
+	default:
+		return fmt.Errorf("unknown integer operator: %d", op)
+	}
+
+	return vm.push(result)
+}
+
+// executeBinaryFloatOperation applies an arithmetic operator to two numbers, at least one of which is a float, and pushes
+// the resulting float onto the stack.
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	lValue := toFloat(left)
+	rValue := toFloat(right)
+
+	var result float64
+	switch op {
+	case code.OpAdd:
+		result = lValue + rValue
+	case code.OpSub:
+		result = lValue - rValue
+	case code.OpMul:
+		result = lValue * rValue
+	case code.OpDiv:
+		if rValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = lValue / rValue
+	case code.OpMod:
+		if rValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = math.Mod(lValue, rValue)
+	case code.OpPow:
+		result = math.Pow(lValue, rValue)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
+// executeBinaryStringOperation applies an arithmetic operator to two strings and pushes the result onto the stack.
+// Concatenation is the only supported operation.
+func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
+	if op != code.OpAdd {
+		return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+
+	lValue := left.(*object.String).Value
+	rValue := right.(*object.String).Value
+
+	if len(lValue)+len(rValue) > object.MaxStringLength {
+		return fmt.Errorf("string too long: more than %d bytes", object.MaxStringLength)
+	}
+
+	return vm.push(&object.String{Value: lValue + rValue})
+}
+
+// executeComparison pops two objects off the stack, compares them and pushes the resulting boolean onto the stack.
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return vm.executeIntegerComparison(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeFloatComparison(op, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return vm.executeStringComparison(op, left, right)
+	}
+
+	// Booleans and null are singletons so they can be compared by their pointers, objects of different types are never equal.
+	switch {
+	case op == code.OpEQ:
+		return vm.push(convertBooleanToObject(left == right))
+	case op == code.OpNEQ:
+		return vm.push(convertBooleanToObject(left != right))
+	case left.Type() != right.Type():
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
+	default:
+		return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+}
+
+// executeIntegerComparison compares two integers and pushes the resulting boolean onto the stack.
+func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
+	cmp := left.(*object.Integer).Cmp(right.(*object.Integer))
+
+	switch op {
+	case code.OpEQ:
+		return vm.push(convertBooleanToObject(cmp == 0))
+	case code.OpNEQ:
+		return vm.push(convertBooleanToObject(cmp != 0))
+	case code.OpGT:
+		return vm.push(convertBooleanToObject(cmp > 0))
+	case code.OpGTE:
+		return vm.push(convertBooleanToObject(cmp >= 0))
+	case code.OpLT:
+		return vm.push(convertBooleanToObject(cmp < 0))
+	case code.OpLTE:
+		return vm.push(convertBooleanToObject(cmp <= 0))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+// executeFloatComparison compares two numbers, at least one of which is a float, and pushes the resulting boolean onto the stack.
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	lValue := toFloat(left)
+	rValue := toFloat(right)
+
+	switch op {
+	case code.OpEQ:
+		return vm.push(convertBooleanToObject(lValue == rValue))
+	case code.OpNEQ:
+		return vm.push(convertBooleanToObject(lValue != rValue))
+	case code.OpGT:
+		return vm.push(convertBooleanToObject(lValue > rValue))
+	case code.OpGTE:
+		return vm.push(convertBooleanToObject(lValue >= rValue))
+	case code.OpLT:
+		return vm.push(convertBooleanToObject(lValue < rValue))
+	case code.OpLTE:
+		return vm.push(convertBooleanToObject(lValue <= rValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+// executeStringComparison compares two strings by their value and pushes the resulting boolean onto the stack.
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	lValue := left.(*object.String).Value
+	rValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEQ:
+		return vm.push(convertBooleanToObject(lValue == rValue))
+	case code.OpNEQ:
+		return vm.push(convertBooleanToObject(lValue != rValue))
+	default:
+		return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+}
+
+// buildArray creates an array from the elements on the stack between startIndex and endIndex.
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+// buildString creates a string by concatenating the string representations of the elements on the stack between
+// startIndex and endIndex.
+func (vm *VM) buildString(startIndex, endIndex int) (object.Object, error) {
+	var out bytes.Buffer
+
+	for i := startIndex; i < endIndex; i++ {
+		s, ok := object.InspectLimited(vm.stack[i], object.MaxStringLength-out.Len())
+		if !ok {
+			return nil, fmt.Errorf("string too long: more than %d bytes", object.MaxStringLength)
+		}
+		out.WriteString(s)
+	}
+
+	return &object.String{Value: out.String()}, nil
+}
+
+// buildHash creates a hash from the alternating keys and values on the stack between startIndex and endIndex.
+// Returns an error if a key is not hashable.
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	pairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unhashable key: %s", key.Type())
+		}
+
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+// executeIndexExpression pushes the element of an array or hash at the given index onto the stack.
+// Null is pushed if the index is out of bounds or the key does not exist.
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		array := left.(*object.Array)
+		idx := index.(*object.Integer)
+
+		// Indexing out of bounds
+		if idx.IsBig() || idx.Value < 0 || idx.Value > int64(len(array.Elements)-1) {
+			return vm.push(NULL)
+		}
+
+		return vm.push(array.Elements[idx.Value])
+	case left.Type() == object.HASH_OBJ:
+		hash := left.(*object.Hash)
+
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unhashable key: %s", index.Type())
+		}
+
+		pair, ok := hash.Pairs[key.HashKey()]
+		if !ok {
+			return vm.push(NULL)
+		}
+
+		return vm.push(pair.Value)
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+// executeIndexAssignment stores a value in an array or hash in place and pushes the value onto the stack.
+func (vm *VM) executeIndexAssignment(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("index must be an integer: %s", index.Type())
+		}
+
+		if idx.IsBig() || idx.Value < 0 || idx.Value > int64(len(left.Elements)-1) {
+			return fmt.Errorf("index out of range: %s", idx.Inspect())
+		}
+
+		left.Elements[idx.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unhashable key: %s", index.Type())
+		}
+
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
 // TODO: Refactor stack into own struct
 
 // pop removes the top object from the stack.
@@ -238,10 +927,10 @@ func (vm *VM) pop() object.Object {
 }
 
 // push adds an object to the top of the stack and increments the pointer.
-// Returns an error if a stackover flow occurs.
+// Returns an error if the stack is full.
 func (vm *VM) push(obj object.Object) error {
 	if vm.sp >= StackSize {
-		return fmt.Errorf("stackover flow")
+		return fmt.Errorf("stack overflow: more than %d elements on the stack", StackSize)
 	}
 
 	vm.stack[vm.sp] = obj
@@ -258,18 +947,35 @@ func convertBooleanToObject(val bool) *object.Boolean {
 	return FALSE
 }
 
-// executeBangOperator negates the last value pushed onto the stack.
-// If the last value is not of type *object.Boolean, it will default to FALSE.
+// executeBangOperator negates the truthiness of the last value pushed onto the stack.
 func (vm *VM) executeBangOperator() error {
 	operand := vm.pop()
 
-	switch operand {
+	return vm.push(convertBooleanToObject(!isTruthy(operand)))
+}
+
+// isNumber checks if an object is either an integer or a float.
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat converts an integer or float to a float.
+func toFloat(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return integer.Float()
+	}
+	return obj.(*object.Float).Value
+}
+
+// isTruthy determines if an object is truthy, see object.IsTruthy for which objects are falsy.
+func isTruthy(obj object.Object) bool {
+	// Booleans are by far the most common condition so they are compared by pointer before anything else
+	switch obj {
 	case TRUE:
-		return vm.push(FALSE)
+		return true
 	case FALSE:
-		return vm.push(TRUE)
-	// BUG: Potential bug here as any object on the stack will have a falsely value prefixed with the bang operator
+		return false
 	default:
-		return vm.push(FALSE)
+		return object.IsTruthy(obj)
 	}
 }
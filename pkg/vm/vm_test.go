@@ -1,10 +1,15 @@
 package vm
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"math/big"
 	"testing"
 
 	"github.com/grantwforsythe/monkeylang/pkg/ast"
+	"github.com/grantwforsythe/monkeylang/pkg/code"
 	"github.com/grantwforsythe/monkeylang/pkg/compiler"
 	"github.com/grantwforsythe/monkeylang/pkg/lexer"
 	"github.com/grantwforsythe/monkeylang/pkg/object"
@@ -34,6 +39,65 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"1; 2", 2},
 		{"-1", -1},
 		{"-50 + 100 + -50", 0},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"5 ** 0", 1},
+		{"2 * 3 % 4", 2},
+		{"0xFF + 0o17 + 0b1010", 280},
+		{"1_000_000", 1000000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBigIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"4294967296 * 4294967296", bigInt("18446744073709551616")},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"2 ** 100", bigInt("1267650600228229401496703205376")},
+		{"99999999999999999999", bigInt("99999999999999999999")},
+		{"99999999999999999999 % 7", 1},
+		{"(2 ** 64) / (2 ** 32)", 4294967296},
+		// The quotient of the smallest 64-bit integer and -1 does not fit in 64 bits so it is promoted
+		{"(-9223372036854775807 - 1) / -1", bigInt("9223372036854775808")},
+		{"(-9223372036854775807 - 1) % -1", 0},
+		{"(2 ** 64) - (2 ** 64) + 1", 1},
+		{"2 ** 64 > 9223372036854775807", true},
+		{"2 ** 64 == 18446744073709551616", true},
+		{"-(2 ** 64) < 0", true},
+		{"2 ** 64 + 0.5", 18446744073709551616.5},
+		{"{2 ** 64: 1}[18446744073709551616]", 1},
+		{"[1, 2, 3][2 ** 64]", NULL},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.14", 3.14},
+		{"1e9", 1e9},
+		{"2.5e-3", 0.0025},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"5 / 2.0", 2.5},
+		{"2.0 * 3", 6.0},
+		{"-1.5", -1.5},
+		{"5.5 % 2", 1.5},
+		{"2 ** 0.5 ** 2", 1.189207115002721},
+		{"2.0 ** -1", 0.5},
+		{"1.5 > 1", true},
+		{"1 < 1.5", true},
+		{"1.0 == 1", true},
+		{"1.5 != 1.5", false},
+		{"2 >= 2.0", true},
+		{"2 <= 1.9", false},
 		// {"-(50 / 2 * 2 + 10 - 5)", -55},
 	}
 
@@ -67,11 +131,565 @@ func TestBooleanExpression(t *testing.T) {
 		{"!5", false},
 		{"!!5", true},
 		{"!(true != false)", false},
+		{"!(if (false) { 5; })", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"3 >= 2", true},
+		{"1 == true", false},
+		{`"1" != 1`, true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || false", false},
+		{"false || true", true},
+		{"true || false", true},
+		{`"a" && 1`, true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"false && (1 + true)", false},
+		{"true || (1 + true)", true},
+		{"let x = 0; false && (x = 1); x == 0", true},
+		{"let x = 0; true || (x = 1); x == 0", true},
+		{"let x = 0; true && (x = 1); x == 1", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (true) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else { 20 } ", 20},
+		{"if (1) { 10 }", 10},
+		{"if (1 < 2) { 10 }", 10},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", NULL},
+		{"if (false) { 10 }", NULL},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i", 10},
+		{"let i = 0; while (true) { if (i > 4) { break; }; let i = i + 1; }; i", 5},
+		{"let sum = 0; for (let i = 0; i < 10; let i = i + 1) { if (i > 7) { continue; }; let sum = sum + i; }; sum", 28},
+		{"let n = 0; for (;;) { let n = n + 1; if (n == 3) { break; } }; n", 3},
+		{"let sum = 0; for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; j < 3; let j = j + 1) { if (j > i) { break; }; let sum = sum + 1; } }; sum", 6},
+		{"let f = fn(n) { let i = 0; while (true) { if (i == n) { return i * 2; }; let i = i + 1; } }; f(4)", 8},
+		{"let f = fn() { for (let i = 0; i < 3; let i = i + 1) { } }; f()", NULL},
+		{"let i = 0; while (i < 100000) { let i = i + 1; }; i", 100000},
+		// Break, continue and return stop the expression they are nested in, discarding the operands evaluated so far
+		{"let arr = []; for (let j = 1; j < 6; j += 1) { arr = push(arr, if (j == 3) { break; } else { j }) }; len(arr)", 2},
+		{"let s = 0; for (let j = 0; j < 5; j += 1) { s = s + (1 + if (j == 2) { continue; } else { j }) }; s", 12},
+		{"let i = 0; while (i < 5) { i += 1; let x = if (i == 2) { break; } else { 0 }; }; i", 2},
+		{"let k = 0; while (k < 20000) { k += 1; let y = [1, if (true) { continue; }]; }; k", 20000},
+		{"let i = 0; while (i < 5) { i += 1; let x = true && [1, if (i == 3) { break; }]; }; i", 3},
+		{"let n = 0; while (true) { n += 1; while (if (n == 3) { break; } else { false }) { } }; n", 3},
+		{"let f = fn() { [1, 2, if (true) { return 3; }] }; f()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 1; let y = 1; x = y = 3; x + y", 6},
+		{"let x = 10; x += 5; x", 15},
+		{"let x = 10; x -= 5; x", 5},
+		{"let x = 10; x *= 5; x", 50},
+		{"let x = 10; x /= 5; x", 2},
+		{"let counter = 0; let inc = fn() { counter += 1 }; inc(); inc(); counter", 2},
+		{"let make = fn() { let c = 0; let inc = fn() { c += 1 }; inc(); inc(); c }; make()", 2},
+		{"let make = fn() { let c = 0; fn() { c += 1 } }; let inc = make(); inc(); inc()", 2},
+		{"let f = fn(x) { let g = fn() { x = x * 2 }; g(); x }; f(21)", 42},
+		{"let f = fn() { let a = 1; let g = fn() { let h = fn() { a += 1 }; h(); a }; g() + a }; f()", 4},
+		{"let f = fn() { let a = 1; a = a + 1; a }; f()", 2},
+		{"let arr = [1, 2, 3]; arr[1] = 5; arr[1]", 5},
+		{"let arr = [1, 2, 3]; arr[2] *= 3; arr[2]", 9},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; h["a"] + h["b"]`, 5},
+		{"let arr = [1, 2, 3]; let f = fn(a) { a[0] = 10 }; f(arr); arr[0]", 10},
+		{"let i = 0; let calls = 0; let arr = [1, 2]; arr[fn() { calls += 1; i }()] += 1; calls", 1},
+		{"let x = 1; x += fn() { x = 10; 2 }(); x", 3},
+		{"let arr = [1]; arr[0] += fn() { arr[0] = 10; 2 }(); arr[0]", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = 2; one + two", 3},
+		{"let one = 1; let two = one + one; one + two", 3},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestGlobalsState(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	globals := make([]object.Object, GlobalsSize)
+
+	var vm *VM
+	for _, input := range []string{"let one = 1;", "let two = one + 1;", "one + two"} {
+		comp := compiler.NewWithState(symbolTable, constants)
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.ByteCode()
+		constants = bytecode.Constants
+
+		vm = NewWithGlobalsState(bytecode, globals)
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+
+	testExpectedObject(t, 3, vm.LastPoppedStackElem())
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let one = fn() { 1; }; let two = fn() { 2; }; one() + two()", 3},
+		{"let a = fn() { 1 }; let b = fn() { a() + 1 }; let c = fn() { b() + 1 }; c();", 3},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let earlyExit = fn() { return 99; return 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", NULL},
+		{"let noReturn = fn() { let a = 1; }; noReturn();", NULL},
+		{"let returnsOne = fn() { 1; }; let returnsOneReturner = fn() { returnsOne; }; returnsOneReturner()();", 1},
+		{"return 10; 9;", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = fn() { let one = 1; one }; one();", 1},
+		{"let oneAndTwo = fn() { let one = 1; let two = 2; one + two; }; oneAndTwo();", 3},
+		{`
+		let firstFoobar = fn() { let foobar = 50; foobar; };
+		let secondFoobar = fn() { let foobar = 100; foobar; };
+		firstFoobar() + secondFoobar();
+		`, 150},
+		{`
+		let globalSeed = 50;
+		let minusOne = fn() { let num = 1; globalSeed - num; }
+		let minusTwo = fn() { let num = 2; globalSeed - num; }
+		minusOne() + minusTwo();
+		`, 97},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let identity = fn(a) { a; }; identity(4);", 4},
+		{"let sum = fn(a, b) { a + b; }; sum(1, 2);", 3},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{`
+		let sum = fn(a, b) { let c = a + b; c; };
+		let outer = fn() { sum(1, 2) + sum(3, 4); };
+		outer();
+		`, 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{"fn() { 1; }(1);", "wrong number of arguments: want=0, got=1"},
+		{"fn(a) { a; }();", "wrong number of arguments: want=1, got=0"},
+		{"fn(a, b) { a + b; }(1);", "wrong number of arguments: want=2, got=1"},
+		{"1();", "not a function: INTEGER"},
+	}
+
+	for _, test := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(test.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.ByteCode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		vmErr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("error is not *Error. got=%T (%+v)", err, err)
+		}
+
+		if vmErr.Message != test.expected {
+			t.Errorf("wrong VM error. want=%q, got=%q", test.expected, vmErr.Message)
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{"let newClosure = fn(a) { fn() { a; }; }; let closure = newClosure(99); closure();", 99},
+		{"let newAdder = fn(a, b) { fn(c) { a + b + c }; }; let adder = newAdder(1, 2); adder(8);", 11},
+		{`
+		let newAdderOuter = fn(a, b) {
+			let c = a + b;
+			fn(d) {
+				let e = d + c;
+				fn(f) { e + f; };
+			};
+		};
+		let newAdderInner = newAdderOuter(1, 2)
+		let adder = newAdderInner(3);
+		adder(8);
+		`, 14},
+		{`
+		let x = 100;
+		let highFn = fn(x) {
+			let y = 5;
+			return fn(z) { x + y + z };
+		};
+		let closure = highFn(2);
+		closure(10);
+		`, 17},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let countDown = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				countDown(x - 1);
+			}
+		};
+		countDown(1);
+		`, 0},
+		{`
+		let wrapper = fn() {
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+		};
+		wrapper();
+		`, 0},
+		{`
+		let fibonacci = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				if (x == 1) {
+					return 1;
+				} else {
+					fibonacci(x - 1) + fibonacci(x - 2);
+				}
+			}
+		};
+		fibonacci(15);
+		`, 610},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key"`, "monkey"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"monkey" == "monkey"`, true},
+		{`"monkey" == "banana"`, false},
+		{`"monkey" != "banana"`, true},
+		{`"a\tb\n\"c\"\u{e9}"`, "a\tb\n\"c\"é"},
+		{"`raw ${x}\\n`", "raw ${x}\\n"},
+		{`"1 + 2 = ${1 + 2}!"`, "1 + 2 = 3!"},
+		{`let name = "monkey"; "Hello, ${name}! ${[1, true]} ${"${name}s"}"`, "Hello, monkey! [1, true] monkeys"},
+		{`let f = fn(x) { "${x * 2}" }; f(2) + f(3)`, "46"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"{}", map[object.HashKey]int64{}},
+		{
+			"{1: 2, 2: 3}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey(): 2,
+				(&object.Integer{Value: 2}).HashKey(): 3,
+			},
+		},
+		{
+			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 2}).HashKey(): 4,
+				(&object.Integer{Value: 6}).HashKey(): 16,
+			},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][0 + 2]", 3},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", NULL},
+		{"[1, 2, 3][99]", NULL},
+		{"[1][-1]", NULL},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1, 2: 2}[2]", 2},
+		{"{1: 1}[0]", NULL},
+		{"{}[0]", NULL},
+		{`{"one": 1}["o" + "ne"]`, 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len("héllo")`, 6},
+		{`runelen("héllo")`, 5},
+		{`runelen("")`, 0},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, NULL},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, NULL},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, NULL},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, NULL},
+		{`push([], 1)`, []int{1}},
+		{`let map = fn(arr, f) { if (len(arr) == 0) { [] } else { push(map(rest(arr), f), f(first(arr))) } }; map([1, 2], fn(x) { x * 2 })`, []int{4, 2}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
+		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{`"Hello" * 2`, "type mismatch: STRING * INTEGER"},
+		{"true > false", "unknown operator: BOOLEAN > BOOLEAN"},
+		{"true >= false", "unknown operator: BOOLEAN >= BOOLEAN"},
+		{"true < false", "unknown operator: BOOLEAN < BOOLEAN"},
+		{"1 <= true", "type mismatch: INTEGER <= BOOLEAN"},
+		{"5 % 0", "division by zero"},
+		{"1 / 0", "division by zero"},
+		{"let zero = 0; 10 / zero", "division by zero"},
+		{"(2 ** 64) / 0", "division by zero"},
+		{"(2 ** 64) % 0", "division by zero"},
+		{"1.5 / 0", "division by zero"},
+		{"1 / 0.0", "division by zero"},
+		{"5.5 % 0.0", "division by zero"},
+		{"let zero = -0.0; 10 % zero", "division by zero"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{"2 ** -1", "negative exponent: -1"},
+		{"2 ** 99999999", "exponent too large: 99999999"},
+		{"let arr = [1]; arr[2 ** 64] = 2", "index out of range: 18446744073709551616"},
+		{"let x = 3; while (true) { x = x * x }", "integer too large: more than 1048576 bits"},
+		{"let f = fn() { f() }; f();", "stack overflow: more than 1024 nested function calls"},
+		{"let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(2000)", "stack overflow: more than 1024 nested function calls"},
+		{"let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(1024)", "stack overflow: more than 1024 nested function calls"},
+		{`let x = "ab"; while (true) { x = x + x }`, "string too long: more than 16777216 bytes"},
+		{`let x = "ab"; while (true) { x = "${x}${x}" }`, "string too long: more than 16777216 bytes"},
+		{`let a = [1]; let i = 0; while (i < 40) { a = [a, a]; i += 1 }; "${a}"`, "string too long: more than 16777216 bytes"},
+		{"true && (1 + true)", "type mismatch: INTEGER + BOOLEAN"},
+		{`{"a": 1}[fn(x) { x }]`, "unhashable key: FUNCTION"},
+		{"fn(x) { x } + 1", "type mismatch: FUNCTION + INTEGER"},
+		{"if (false) { let a = 1 }; a", "identifier not found: a"},
+		{"if (false) { let a = 1 }; a = 2", "identifier not found: a"},
+		{"let f = fn(x) { if (x) { let a = 1 }; a }; f(false)", "identifier not found: a"},
+		// The locals of a call start out unset rather than with what the previous call left on the stack
+		{"let g = fn() { let q = 5; q }; let f = fn() { if (false) { let a = 1 }; a }; g(); f()", "identifier not found: a"},
+		{"let f = fn() { if (false) { let a = 1 }; let h = fn() { a = 2 }; a }; f()", "identifier not found: a"},
+		{`{[1]: 1}`, "unhashable key: ARRAY"},
+		{"1[0]", "index operator not supported: INTEGER"},
+		{`len(1)`, "argument to `len` not supported. got=INTEGER"},
+		{"let x = true; x += 1", "type mismatch: BOOLEAN + INTEGER"},
+		{"let arr = [1]; arr[1] = 2", "index out of range: 1"},
+		{`let arr = [1]; arr["a"] = 2`, "index must be an integer: STRING"},
+		{`let h = {}; h[[1]] = 2`, "unhashable key: ARRAY"},
+		{"let x = 1; x[0] = 2", "index assignment not supported: INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`runelen([])`, "argument to `runelen` not supported. got=ARRAY"},
+		{`first(1)`, "'first' only accepts an array as an argument. got=INTEGER"},
+		{`push(1, 1)`, "the first argument needs to be of type ARRAY. got=INTEGER"},
+	}
+
+	for _, test := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(test.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.ByteCode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		vmErr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("error is not *Error. got=%T (%+v)", err, err)
+		}
+
+		if vmErr.Message != test.expected {
+			t.Errorf("wrong VM error. want=%q, got=%q", test.expected, vmErr.Message)
+		}
+	}
+}
+
+// Bytecode loaded from a file is validated before it is run, but what depends on the values at runtime can only be
+// checked by the virtual machine.
+func TestRuntimeErrorsFromByteCode(t *testing.T) {
+	concat := func(instructions ...code.Instructions) code.Instructions {
+		out := code.Instructions{}
+		for _, ins := range instructions {
+			out = append(out, ins...)
+		}
+		return out
+	}
+
+	callFunction := concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpCall, 0), code.Make(code.OpPop))
+
+	tests := []struct {
+		name     string
+		bytecode *compiler.ByteCode
+		expected string
+	}{
+		{
+			"unset global",
+			&compiler.ByteCode{
+				Instructions: concat(code.Make(code.OpGetGlobal, 5), code.Make(code.OpTrue), code.Make(code.OpAdd), code.Make(code.OpPop)),
+			},
+			"global 5 is not set",
+		},
+		{
+			"unset local",
+			&compiler.ByteCode{
+				Constants: []object.Object{
+					&object.CompiledFunction{
+						Instructions: concat(code.Make(code.OpGetLocal, 0), code.Make(code.OpReturnValue)),
+						NumLocals:    1,
+					},
+				},
+				Instructions: callFunction,
+			},
+			"local 0 is not set",
+		},
+		{
+			"missing free variable",
+			&compiler.ByteCode{
+				Constants: []object.Object{
+					&object.CompiledFunction{Instructions: concat(code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+				},
+				Instructions: callFunction,
+			},
+			"free variable 0 does not exist",
+		},
+		{
+			"dereference a value that is not a cell",
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpDeref), code.Make(code.OpPop))},
+			"not a cell",
+		},
+	}
+
+	for _, test := range tests {
+		vm := New(test.bytecode)
+		err := vm.Run()
+
+		vmErr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("%s: error is not *Error. got=%T (%+v)", test.name, err, err)
+		}
+
+		if vmErr.Message != test.expected {
+			t.Errorf("%s: wrong VM error. want=%q, got=%q", test.name, test.expected, vmErr.Message)
+		}
+	}
+}
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedPosition string
+	}{
+		{"5 + true;", "1:3"},
+		{"let x = 1;\nlet y = x + true;", "2:11"},
+		{"let f = fn(a) {\n  -a\n};\nf(true);", "2:3"},
+		{"len(1)", "1:4"},
+	}
+
+	for _, test := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(test.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.ByteCode())
+		err = vm.Run()
+
+		vmErr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("error is not *Error. got=%T (%+v)", err, err)
+		}
+
+		if vmErr.Pos.String() != test.expectedPosition {
+			t.Errorf("wrong VM error position. want=%q, got=%q", test.expectedPosition, vmErr.Pos)
+		}
+	}
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
@@ -90,7 +708,7 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("vm error: %s", err)
 		}
 
-		stackElem := vm.lastPoppedStackElem()
+		stackElem := vm.LastPoppedStackElem()
 
 		testExpectedObject(t, test.expected, stackElem)
 	}
@@ -110,12 +728,78 @@ func testExpectedObject(
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
 
+	case *big.Int:
+		err := testBigIntegerObject(expected, actual)
+		if err != nil {
+			t.Errorf("testBigIntegerObject failed: %s", err)
+		}
+
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
+
 	case bool:
 		err := testBooleanObject(expected, actual)
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
 
+	case string:
+		err := testStringObject(expected, actual)
+		if err != nil {
+			t.Errorf("testStringObject failed: %s", err)
+		}
+
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+
+		for i, expectedElem := range expected {
+			err := testIntegerObject(int64(expectedElem), array.Elements[i])
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
+			return
+		}
+
+		if len(hash.Pairs) != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+			return
+		}
+
+		for expectedKey, expectedValue := range expected {
+			pair, ok := hash.Pairs[expectedKey]
+			if !ok {
+				t.Errorf("no pair for given key in Pairs")
+			}
+
+			err := testIntegerObject(expectedValue, pair.Value)
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+
+	case *object.Null:
+		if actual != NULL {
+			t.Errorf("object is not Null. got=%T (%+v)", actual, actual)
+		}
+
 	default:
 		t.Errorf("unable to evaluate type: %+v", expected)
 	}
@@ -134,6 +818,10 @@ func testIntegerObject(expected int64, actual object.Object) error {
 			actual, actual)
 	}
 
+	if result.IsBig() {
+		return fmt.Errorf("object was not demoted to a small integer. got=%s", result.Inspect())
+	}
+
 	if result.Value != expected {
 		return fmt.Errorf("object has wrong value. got=%d, want=%d",
 			result.Value, expected)
@@ -142,6 +830,46 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testBigIntegerObject(expected *big.Int, actual object.Object) error {
+	result, ok := actual.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("object is not Integer. got=%T (%+v)", actual, actual)
+	}
+
+	if !result.IsBig() {
+		return fmt.Errorf("object was not promoted to a big integer. got=%d", result.Value)
+	}
+
+	if result.Big.Cmp(expected) != 0 {
+		return fmt.Errorf("object has wrong value. got=%s, want=%s", result.Big, expected)
+	}
+
+	return nil
+}
+
+// bigInt parses a decimal integer that is too large to be written as an integer literal in go.
+func bigInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer: " + s)
+	}
+	return n
+}
+
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -154,3 +882,122 @@ func testBooleanObject(expected bool, actual object.Object) error {
 
 	return nil
 }
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q", result.Value, expected)
+	}
+
+	return nil
+}
+
+func TestStepBudget(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse("while (true) { }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.ByteCode())
+	vm.SetStepBudget(100000)
+
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	vmErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error is not *Error. got=%T (%+v)", err, err)
+	}
+
+	if vmErr.Message != "step budget exhausted" {
+		t.Errorf("wrong VM error. want=%q, got=%q", "step budget exhausted", vmErr.Message)
+	}
+}
+
+func FuzzRun(f *testing.F) {
+	f.Add("let add = fn(x, y) { x + y; }; add(1, 2);")
+	f.Add(`let h = {"a": [1, 2.5], true: "${1 + 1}"}; h["a"][0] += 1; h`)
+	f.Add("let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10);")
+	f.Add("let x = 0; for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } x += i; } x")
+	f.Add(`let f = fn() { let a = 1; fn() { a += 1; a } }(); f(); rest(push([1], "a")); 2 ** 70 / 3`)
+	f.Add(`puts("a", [1]); quit(); 1`)
+	stubBuiltins(f)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			return
+		}
+
+		// The budget keeps programs that never terminate from stalling the fuzzer
+		vm := New(comp.ByteCode())
+		vm.SetStepBudget(10000)
+
+		// Errors are expected for most inputs, only a panic is a failure
+		_ = vm.Run()
+	})
+}
+
+// FuzzRunByteCode runs bytecode loaded from a file, which may have been built by hand rather than by the compiler.
+func FuzzRunByteCode(f *testing.F) {
+	header := len(compiler.ByteCodeMagic) + 2
+
+	for _, input := range []string{
+		"let add = fn(x, y) { x + y; }; add(1, 2);",
+		"let x = 0; for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } x += i; } x",
+		`let f = fn() { let a = 1; fn() { a += 1; a } }(); f(); [len("${f}"), {"a": first([1])}["a"]]`,
+		`puts("a", [1]); quit(); 1`,
+	} {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			f.Fatalf("compiler error: %s", err)
+		}
+
+		data, err := comp.ByteCode().MarshalBinary()
+		if err != nil {
+			f.Fatalf("MarshalBinary failed: %s", err)
+		}
+		f.Add(data[header : len(data)-4])
+	}
+	stubBuiltins(f)
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		// Only the body is fuzzed, the header and checksum would reject almost every mutation before it is decoded
+		data := binary.BigEndian.AppendUint16([]byte(compiler.ByteCodeMagic), compiler.ByteCodeVersion)
+		data = append(data, body...)
+		data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+
+		bytecode := &compiler.ByteCode{}
+		if err := bytecode.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		vm := New(bytecode)
+		vm.SetStepBudget(10000)
+
+		// Anything that decodes successfully may fail while running, but must never panic
+		_ = vm.Run()
+	})
+}
+
+// stubBuiltins keeps the programs being fuzzed from ending the fuzzer with quit or flooding its output with puts.
+func stubBuiltins(f *testing.F) {
+	exit, stdout := object.Exit, object.Stdout
+	f.Cleanup(func() { object.Exit, object.Stdout = exit, stdout })
+
+	object.Exit = func(int) {}
+	object.Stdout = io.Discard
+}